@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/romana/rlog"
+)
+
+// Source IP allowlist for webhook routes, X-Forwarded-For is used only when request came from trusted proxy
+type ipAllowlist struct {
+	allowed        []*net.IPNet
+	trustedProxies []*net.IPNet
+}
+
+// Parse comma separated list of CIDRs, bare IP is treated as single address network
+func parseCIDRList(value string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("not an IP address or CIDR: %s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client IP is the first address from the right in X-Forwarded-For which is not a trusted proxy,
+// header is ignored if remote address is not a trusted proxy, as anybody can set it
+func (a *ipAllowlist) ClientIP(c *fiber.Ctx) net.IP {
+	remoteIP := c.Context().RemoteIP()
+	if !containsIP(a.trustedProxies, remoteIP) {
+		return remoteIP
+	}
+	forwarded := c.Get(fiber.HeaderXForwardedFor)
+	if forwarded == "" {
+		return remoteIP
+	}
+	hops := strings.Split(forwarded, ",")
+	var clientIP net.IP = remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		hopIP := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hopIP == nil {
+			// malformed hop can't be trusted, last valid address is used
+			break
+		}
+		clientIP = hopIP
+		if !containsIP(a.trustedProxies, hopIP) {
+			break
+		}
+	}
+	return clientIP
+}
+
+// Middleware rejecting requests from addresses not in allowlist with 403, allows everything when allowlist is empty
+func (a *ipAllowlist) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if len(a.allowed) == 0 {
+			return c.Next()
+		}
+		clientIP := a.ClientIP(c)
+		if containsIP(a.allowed, clientIP) {
+			return c.Next()
+		}
+		rlog.Errorf("Request from %s (remote %s) is not allowed by ALLOWED_CIDRS", clientIP, c.Context().RemoteIP())
+		c.Set("Content-Type", "text/plain; charset=utf-8")
+		return c.Status(403).SendString("Error: source address is not allowed")
+	}
+}
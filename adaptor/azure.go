@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// Azure DevOps service hooks have no event header by default, event name is in eventType field of payload
+// https://learn.microsoft.com/en-us/azure/devops/service-hooks/events#git.pullrequest.created
+
+type AzureIdentity struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	UniqueName  string `json:"uniqueName"`
+}
+
+type AzureReviewer struct {
+	AzureIdentity
+	Vote       int  `json:"vote"`
+	IsRequired bool `json:"isRequired"`
+}
+
+type AzureRepository struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	RemoteURL string `json:"remoteUrl"`
+	WebURL    string `json:"webUrl"`
+	Project   struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+type AzurePullRequest struct {
+	PullRequestID         int             `json:"pullRequestId"`
+	Title                 string          `json:"title"`
+	Description           string          `json:"description"`
+	Status                string          `json:"status"`
+	MergeStatus           string          `json:"mergeStatus"`
+	IsDraft               bool            `json:"isDraft"`
+	SourceRefName         string          `json:"sourceRefName"`
+	TargetRefName         string          `json:"targetRefName"`
+	CreatedBy             AzureIdentity   `json:"createdBy"`
+	ClosedBy              *AzureIdentity  `json:"closedBy"`
+	Reviewers             []AzureReviewer `json:"reviewers"`
+	Repository            AzureRepository `json:"repository"`
+	URL                   string          `json:"url"`
+	LastMergeSourceCommit struct {
+		CommitID string `json:"commitId"`
+	} `json:"lastMergeSourceCommit"`
+	LastMergeTargetCommit struct {
+		CommitID string `json:"commitId"`
+	} `json:"lastMergeTargetCommit"`
+	Links struct {
+		Web struct {
+			Href string `json:"href"`
+		} `json:"web"`
+	} `json:"_links"`
+}
+
+type AzurePREvent struct {
+	ID          string `json:"id"`
+	EventType   string `json:"eventType"`
+	PublisherID string `json:"publisherId"`
+	Message     struct {
+		Text string `json:"text"`
+	} `json:"message"`
+	CreatedDate string `json:"createdDate"`
+	Resource    struct {
+		AzurePullRequest
+		// ms.vss-code.git-pullrequest-comment-event
+		Comment *struct {
+			ID              int           `json:"id"`
+			ParentCommentID int           `json:"parentCommentId"`
+			Content         string        `json:"content"`
+			Author          AzureIdentity `json:"author"`
+		} `json:"comment"`
+		PullRequest *AzurePullRequest `json:"pullRequest"`
+	} `json:"resource"`
+}
+
+// Request came from Azure DevOps if payload was published by tfs with PR event type
+func isAzureEvent(publisherID, eventType string) bool {
+	return publisherID == "tfs" && (strings.HasPrefix(eventType, "git.pullrequest.") || eventType == "ms.vss-code.git-pullrequest-comment-event")
+}
+
+// uniqueName is the UPN of Azure AD user, used as mention id
+func (i AzureIdentity) toBitBucketUser() BitBucketUser {
+	var user BitBucketUser
+	user.Name, _, _ = strings.Cut(i.UniqueName, "@")
+	if user.Name == "" {
+		user.Name = i.DisplayName
+	}
+	user.EmailAddress = i.UniqueName
+	user.DisplayName = i.DisplayName
+	user.Slug = i.ID
+	return user
+}
+
+func azureRef(refName, commit string, repository AzureRepository) BitBucketRef {
+	var ref BitBucketRef
+	ref.ID = refName
+	ref.DisplayID = strings.TrimPrefix(refName, "refs/heads/")
+	ref.LatestCommit = commit
+	ref.Type = "BRANCH"
+	ref.Repository.Slug = repository.Name
+	ref.Repository.Name = repository.Name
+	ref.Repository.Project.Name = repository.Project.Name
+	return ref
+}
+
+// Web link of PR, service hooks payload has API url in url field
+func (pr AzurePullRequest) webURL() string {
+	if pr.Links.Web.Href != "" {
+		return pr.Links.Web.Href
+	}
+	repoURL := pr.Repository.WebURL
+	if repoURL == "" {
+		repoURL = pr.Repository.RemoteURL
+	}
+	if repoURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/pullrequest/%d", repoURL, pr.PullRequestID)
+}
+
+// Maps Azure DevOps event type to BitBucket Server eventKey with the same meaning, empty if event should not be sent
+func azureEventKey(inventory AzurePREvent, pr AzurePullRequest) string {
+	switch inventory.EventType {
+	case "git.pullrequest.created":
+		return "pr:opened"
+	case "git.pullrequest.updated":
+		switch {
+		case pr.Status == "completed":
+			return "pr:merged"
+		case pr.Status == "abandoned":
+			return "pr:declined"
+		case strings.Contains(inventory.Message.Text, "source branch"):
+			return "pr:from_ref_updated"
+		default:
+			return "pr:modified"
+		}
+	case "git.pullrequest.merged":
+		// merge attempt is reported before PR completion too, only completed PR is announced
+		if pr.Status == "completed" {
+			return "pr:merged"
+		}
+	case "ms.vss-code.git-pullrequest-comment-event":
+		return "pr:comment:added"
+	}
+	return ""
+}
+
+// Parse Azure DevOps pull request service hook json payload, maps data to build Teams notification webhook json
+func ParseAzurePR(eventJson []byte) ([]byte, error) {
+	var inventory AzurePREvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling Azure DevOps payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "azure inventory : %+v\n", inventory)
+
+	// comment event has PR nested in resource.pullRequest
+	src := inventory.Resource.AzurePullRequest
+	if inventory.Resource.PullRequest != nil {
+		src = *inventory.Resource.PullRequest
+	}
+
+	var event BitBucketPREvent
+	event.EventKey = azureEventKey(inventory, src)
+	if event.EventKey == "" {
+		rlog.Debugf("Azure DevOps event %s with PR status '%s' is not routed", inventory.EventType, src.Status)
+		return []byte(""), ErrEventIgnored
+	}
+
+	pr := &event.PullRequest
+	pr.ID = src.PullRequestID
+	pr.Title = src.Title
+	pr.Description = src.Description
+	pr.State = strings.ToUpper(src.Status)
+	pr.Open = src.Status == "active"
+	pr.Closed = !pr.Open
+	pr.FromRef = azureRef(src.SourceRefName, src.LastMergeSourceCommit.CommitID, src.Repository)
+	pr.ToRef = azureRef(src.TargetRefName, src.LastMergeTargetCommit.CommitID, src.Repository)
+	pr.Author.User = src.CreatedBy.toBitBucketUser()
+	pr.Author.Role = "AUTHOR"
+	pr.Links.Self = append(pr.Links.Self, struct {
+		Href string `json:"href"`
+	}{Href: src.webURL()})
+	for _, val := range src.Reviewers {
+		var reviewer BitBucketParticipant
+		reviewer.User = val.toBitBucketUser()
+		reviewer.Role = "REVIEWER"
+		// vote: 10 approved, 5 approved with suggestions, 0 no vote, -5 waiting for author, -10 rejected
+		reviewer.Approved = val.Vote >= 5
+		switch {
+		case val.Vote >= 5:
+			reviewer.Status = "APPROVED"
+		case val.Vote < 0:
+			reviewer.Status = "NEEDS_WORK"
+		default:
+			reviewer.Status = "UNAPPROVED"
+		}
+		pr.Reviewers = append(pr.Reviewers, reviewer)
+	}
+
+	// service hooks don't carry actor, closing user is known for completed and abandoned PRs only
+	if src.ClosedBy != nil && (event.EventKey == "pr:merged" || event.EventKey == "pr:declined") {
+		event.Actor = src.ClosedBy.toBitBucketUser()
+	}
+	if inventory.Resource.Comment != nil {
+		event.Actor = inventory.Resource.Comment.Author.toBitBucketUser()
+		event.Comment = &BitBucketComment{
+			ID:     inventory.Resource.Comment.ID,
+			Text:   inventory.Resource.Comment.Content,
+			Author: event.Actor,
+		}
+		event.CommentParentID = inventory.Resource.Comment.ParentCommentID
+	}
+
+	return RenderPR(event)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// Build status payload has commit only, open PRs are remembered by their source branch latest commit
+// from PR events passed through adaptor, so failed build can be reported to PR author.
+// Commit is scoped by route and source repository, forks share commit hashes with their upstream.
+const prCommitCacheSize = 10000
+
+type prCommitCache struct {
+	mu    sync.Mutex
+	prs   map[string]*list.Element // by prCommitKey
+	order *list.List               // insertion order for eviction of oldest entries, front is oldest
+}
+
+type prCommitEntry struct {
+	key string
+	pr  BitBucketPullRequest
+}
+
+func prCommitKey(route string, repository BitBucketRepository, commit string) string {
+	return route + "|" + repository.Project.Key + "/" + repository.Slug + "|" + commit
+}
+
+var openPRCommits = &prCommitCache{prs: make(map[string]*list.Element), order: list.New()}
+
+func (c *prCommitCache) Put(key string, pr BitBucketPullRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.prs[key]; ok {
+		element.Value.(*prCommitEntry).pr = pr
+		return
+	}
+	c.prs[key] = c.order.PushBack(&prCommitEntry{key: key, pr: pr})
+	for c.order.Len() > prCommitCacheSize {
+		oldest := c.order.Front()
+		delete(c.prs, oldest.Value.(*prCommitEntry).key)
+		c.order.Remove(oldest)
+	}
+}
+
+func (c *prCommitCache) Get(key string) (BitBucketPullRequest, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.prs[key]
+	if !ok {
+		return BitBucketPullRequest{}, false
+	}
+	return element.Value.(*prCommitEntry).pr, true
+}
+
+func (c *prCommitCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.prs[key]; ok {
+		c.order.Remove(element)
+		delete(c.prs, key)
+	}
+}
+
+// Keep track of open PR latest commit on route, closed PRs are forgotten
+func rememberPRCommit(route string, inventory BitBucketPREvent) {
+	pr := inventory.PullRequest
+	if pr.FromRef.LatestCommit == "" {
+		return
+	}
+	repository := pr.FromRef.Repository // build status is reported for the repository commit was pushed to
+	if inventory.PreviousFromHash != "" {
+		openPRCommits.Delete(prCommitKey(route, repository, inventory.PreviousFromHash))
+	}
+	key := prCommitKey(route, repository, pr.FromRef.LatestCommit)
+	switch inventory.EventKey {
+	case "pr:merged", "pr:declined", "pr:deleted":
+		openPRCommits.Delete(key)
+	default:
+		if pr.State == "" || pr.State == "OPEN" {
+			openPRCommits.Put(key, pr)
+		}
+	}
+}
+
+type BitBucketBuildStatus struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Ref         string `json:"ref"`
+	Parent      string `json:"parent"`
+	BuildNumber string `json:"buildNumber"`
+}
+
+// repo:commit_status_created, repo:commit_status_updated
+type BitBucketBuildStatusEvent struct {
+	EventKey   string               `json:"eventKey"`
+	Date       string               `json:"date"`
+	Actor      BitBucketUser        `json:"actor"`
+	Repository BitBucketRepository  `json:"repository"`
+	Commit     string               `json:"commit"`
+	Status     BitBucketBuildStatus `json:"status"`
+}
+
+// Request payload has build status event eventKey
+func isBuildStatusEvent(eventKey string) bool {
+	return strings.HasPrefix(eventKey, "repo:commit_status_")
+}
+
+// Parse BitBucket build status event json payload received on route, failed build of open PR latest commit
+// seen on the same route is mapped to Teams notification webhook json
+func ParseBuildStatus(route string, eventJson []byte) ([]byte, error) {
+	var inventory BitBucketBuildStatusEvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "build status inventory : %+v\n", inventory)
+
+	if inventory.Status.State != "FAILED" {
+		rlog.Debugf("Build status %s of commit %s is not notified", inventory.Status.State, inventory.Commit)
+		return []byte(""), ErrEventIgnored
+	}
+	pr, ok := openPRCommits.Get(prCommitKey(route, inventory.Repository, inventory.Commit))
+	if !ok {
+		rlog.Debugf("Failed build of commit %s doesn't belong to known open PR", inventory.Commit)
+		return []byte(""), ErrEventIgnored
+	}
+
+	var entities ReviewerEntitiesList
+	authorEntity := bitBucketMention(pr.Author.User)
+	entities.Add(authorEntity)
+
+	buildName := inventory.Status.Name
+	if buildName == "" {
+		buildName = inventory.Status.Key
+	}
+	if inventory.Status.URL != "" {
+		buildName = fmt.Sprintf("[%s](%s)", buildName, inventory.Status.URL)
+	}
+	bodyText := fmt.Sprintf("Hi %s, build %s failed for commit `%s` of PR [%s](%s) \n\n", authorEntity.Text, buildName, shortHash(inventory.Commit), pr.Title, pr.Links.Href())
+	if inventory.Status.Description != "" {
+		bodyText += fmt.Sprintf("%s \n\n", inventory.Status.Description)
+	}
+	rlog.Tracef(0, "bodyText : %s \n", bodyText)
+
+	return BuildTeamsMsg(bodyText, entities)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// BitBucket Cloud sends event name in X-Event-Key header, payload has no eventKey field
+// https://support.atlassian.com/bitbucket-cloud/docs/event-payloads/
+
+type BitBucketCloudLink struct {
+	Href string `json:"href"`
+}
+
+type BitBucketCloudUser struct {
+	DisplayName string `json:"display_name"`
+	Nickname    string `json:"nickname"`
+	AccountID   string `json:"account_id"`
+	UUID        string `json:"uuid"`
+	Type        string `json:"type"`
+	Links       struct {
+		HTML BitBucketCloudLink `json:"html"`
+	} `json:"links"`
+}
+
+type BitBucketCloudParticipant struct {
+	User     BitBucketCloudUser `json:"user"`
+	Role     string             `json:"role"`
+	Approved bool               `json:"approved"`
+	State    string             `json:"state"`
+}
+
+type BitBucketCloudRepository struct {
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	UUID     string `json:"uuid"`
+	Links    struct {
+		HTML BitBucketCloudLink `json:"html"`
+	} `json:"links"`
+}
+
+type BitBucketCloudEndpoint struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+	Repository BitBucketCloudRepository `json:"repository"`
+}
+
+type BitBucketCloudPullRequest struct {
+	ID           int                         `json:"id"`
+	Title        string                      `json:"title"`
+	Description  string                      `json:"description"`
+	State        string                      `json:"state"`
+	Author       BitBucketCloudUser          `json:"author"`
+	Source       BitBucketCloudEndpoint      `json:"source"`
+	Destination  BitBucketCloudEndpoint      `json:"destination"`
+	Reviewers    []BitBucketCloudUser        `json:"reviewers"`
+	Participants []BitBucketCloudParticipant `json:"participants"`
+	CreatedOn    string                      `json:"created_on"`
+	UpdatedOn    string                      `json:"updated_on"`
+	Links        struct {
+		HTML BitBucketCloudLink `json:"html"`
+	} `json:"links"`
+}
+
+type BitBucketCloudPREvent struct {
+	Actor       BitBucketCloudUser        `json:"actor"`
+	PullRequest BitBucketCloudPullRequest `json:"pullrequest"`
+	Repository  BitBucketCloudRepository  `json:"repository"`
+	// pullrequest:approved, pullrequest:unapproved
+	Approval *struct {
+		Date string             `json:"date"`
+		User BitBucketCloudUser `json:"user"`
+	} `json:"approval"`
+	// pullrequest:changes_request_created, pullrequest:changes_request_removed
+	ChangesRequest *struct {
+		Date string             `json:"date"`
+		User BitBucketCloudUser `json:"user"`
+	} `json:"changes_request"`
+	// pullrequest:comment_*
+	Comment *struct {
+		ID      int `json:"id"`
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+		User   BitBucketCloudUser `json:"user"`
+		Parent *struct {
+			ID int `json:"id"`
+		} `json:"parent"`
+	} `json:"comment"`
+}
+
+// BitBucket Cloud event names mapped to BitBucket Server eventKey with the same meaning
+var bitBucketCloudEventKeys = map[string]string{
+	"pullrequest:created":                 "pr:opened",
+	"pullrequest:updated":                 "pr:modified",
+	"pullrequest:approved":                "pr:reviewer:approved",
+	"pullrequest:unapproved":              "pr:reviewer:unapproved",
+	"pullrequest:changes_request_created": "pr:reviewer:needs_work",
+	"pullrequest:changes_request_removed": "pr:reviewer:changes_request_removed",
+	"pullrequest:fulfilled":               "pr:merged",
+	"pullrequest:rejected":                "pr:declined",
+	"pullrequest:comment_created":         "pr:comment:added",
+	"pullrequest:comment_updated":         "pr:comment:edited",
+	"pullrequest:comment_deleted":         "pr:comment:deleted",
+}
+
+// Request came from BitBucket Cloud if it has X-Hook-UUID header or X-Event-Key header without eventKey in payload,
+// BitBucket Server sends eventKey in both
+func isBitBucketCloudEvent(eventKey string, hookUUID string, payloadEventKey string) bool {
+	return hookUUID != "" || (eventKey != "" && payloadEventKey == "")
+}
+
+// Cloud API doesn't expose emails, account_id is used as mention id and nickname as mention text
+func (u BitBucketCloudUser) toBitBucketUser() BitBucketUser {
+	var user BitBucketUser
+	user.Name = u.Nickname
+	if user.Name == "" {
+		user.Name = u.DisplayName
+	}
+	user.EmailAddress = u.AccountID
+	user.DisplayName = u.DisplayName
+	user.Slug = u.Nickname
+	user.Type = u.Type
+	return user
+}
+
+func (e BitBucketCloudEndpoint) toBitBucketRef() BitBucketRef {
+	var ref BitBucketRef
+	ref.ID = "refs/heads/" + e.Branch.Name
+	ref.DisplayID = e.Branch.Name
+	ref.LatestCommit = e.Commit.Hash
+	ref.Type = "BRANCH"
+	ref.Repository.Slug = e.Repository.Name
+	ref.Repository.Name = e.Repository.Name
+	return ref
+}
+
+// Parse BitBucket Cloud PR event json payload, maps data to build Teams notification webhook json
+func ParseBitBucketCloudPR(eventKey string, eventJson []byte) ([]byte, error) {
+	if _, ok := bitBucketCloudEventKeys[eventKey]; !ok {
+		rlog.Debugf("BitBucket Cloud event %s is not routed", eventKey)
+		return []byte(""), ErrEventIgnored
+	}
+	var inventory BitBucketCloudPREvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling BitBucket Cloud payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "cloud inventory : %+v\n", inventory)
+
+	var event BitBucketPREvent
+	event.EventKey = bitBucketCloudEventKeys[eventKey]
+	event.Actor = inventory.Actor.toBitBucketUser()
+
+	src := inventory.PullRequest
+	pr := &event.PullRequest
+	pr.ID = src.ID
+	pr.Title = src.Title
+	pr.Description = src.Description
+	pr.State = src.State
+	pr.Open = src.State == "OPEN"
+	pr.Closed = !pr.Open
+	pr.FromRef = src.Source.toBitBucketRef()
+	pr.ToRef = src.Destination.toBitBucketRef()
+	pr.Author.User = src.Author.toBitBucketUser()
+	pr.Author.Role = "AUTHOR"
+	pr.Links.Self = append(pr.Links.Self, struct {
+		Href string `json:"href"`
+	}{Href: src.Links.HTML.Href})
+	for _, val := range src.Reviewers {
+		var reviewer BitBucketParticipant
+		reviewer.User = val.toBitBucketUser()
+		reviewer.Role = "REVIEWER"
+		for _, p := range src.Participants {
+			if p.User.AccountID == val.AccountID {
+				reviewer.Approved = p.Approved
+				reviewer.Status = strings.ToUpper(p.State)
+			}
+		}
+		pr.Reviewers = append(pr.Reviewers, reviewer)
+	}
+
+	if inventory.Approval != nil {
+		event.Participant = &BitBucketParticipant{User: inventory.Approval.User.toBitBucketUser(), Role: "REVIEWER"}
+	}
+	if inventory.ChangesRequest != nil {
+		event.Participant = &BitBucketParticipant{User: inventory.ChangesRequest.User.toBitBucketUser(), Role: "REVIEWER"}
+	}
+	if inventory.Comment != nil {
+		event.Comment = &BitBucketComment{
+			ID:     inventory.Comment.ID,
+			Text:   inventory.Comment.Content.Raw,
+			Author: inventory.Comment.User.toBitBucketUser(),
+		}
+		if inventory.Comment.Parent != nil {
+			event.CommentParentID = inventory.Comment.Parent.ID
+		}
+	}
+
+	return RenderPR(event)
+}
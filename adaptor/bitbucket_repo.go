@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// Branch patterns (path.Match syntax) which trigger push notification, tags are always notified on create and delete
+var pushNotifyBranches = []string{"main", "master", "release/*"}
+
+type BitBucketRefChange struct {
+	Ref struct {
+		ID        string `json:"id"`
+		DisplayID string `json:"displayId"`
+		Type      string `json:"type"`
+	} `json:"ref"`
+	RefID    string `json:"refId"`
+	FromHash string `json:"fromHash"`
+	ToHash   string `json:"toHash"`
+	Type     string `json:"type"`
+}
+
+type BitBucketRefsChangedEvent struct {
+	EventKey   string               `json:"eventKey"`
+	Date       string               `json:"date"`
+	Actor      BitBucketUser        `json:"actor"`
+	Repository BitBucketRepository  `json:"repository"`
+	Changes    []BitBucketRefChange `json:"changes"`
+}
+
+// Returns first self link if present
+func (r BitBucketRepository) Href() string {
+	for _, val := range r.Links.Self {
+		if val.Href != "" {
+			return val.Href
+		}
+	}
+	return ""
+}
+
+// Markdown link to repository as PROJ/repo
+func (r BitBucketRepository) markdownLink() string {
+	name := r.Project.Key + "/" + r.Slug
+	if href := r.Href(); href != "" {
+		return fmt.Sprintf("[%s](%s)", name, href)
+	}
+	return name
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[0:7]
+	}
+	return hash
+}
+
+// Ref is notified if it's a tag being created or deleted or a branch matching pushNotifyBranches
+func isNotifiedRefChange(change BitBucketRefChange) bool {
+	if change.Ref.Type == "TAG" || strings.HasPrefix(change.RefID, "refs/tags/") {
+		return change.Type == "ADD" || change.Type == "DELETE"
+	}
+	branch := change.Ref.DisplayID
+	if branch == "" {
+		branch = strings.TrimPrefix(change.RefID, "refs/heads/")
+	}
+	for _, pattern := range pushNotifyBranches {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse BitBucket repo:refs_changed event json payload, maps pushes to protected branches and tag changes to Teams notification webhook json
+func ParseRefsChanged(eventJson []byte) ([]byte, error) {
+	var inventory BitBucketRefsChangedEvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "refs inventory : %+v\n", inventory)
+
+	var changesList string = ""
+	for _, change := range inventory.Changes {
+		if !isNotifiedRefChange(change) {
+			rlog.Debugf("Ref change %s %s is not notified", change.Type, change.RefID)
+			continue
+		}
+		refType := strings.ToLower(change.Ref.Type)
+		if refType == "" {
+			refType = "ref"
+		}
+		var action string
+		switch change.Type {
+		case "ADD":
+			action = "created"
+		case "DELETE":
+			action = "deleted"
+		default:
+			action = "updated"
+		}
+		changesList += fmt.Sprintf("- %s %s `%s`: %s → %s \n\n", refType, action, change.Ref.DisplayID, shortHash(change.FromHash), shortHash(change.ToHash))
+	}
+	if changesList == "" {
+		return []byte(""), ErrEventIgnored
+	}
+
+	var entities ReviewerEntitiesList
+	actorEntity := bitBucketMention(inventory.Actor)
+	entities.Add(actorEntity)
+
+	bodyText := fmt.Sprintf("Hi Team, %s pushed to %s: \n\n", actorEntity.Text, inventory.Repository.markdownLink())
+	bodyText += changesList
+	rlog.Tracef(0, "bodyText : %s \n", bodyText)
+
+	return BuildTeamsMsg(bodyText, entities)
+}
+
+type BitBucketRepoEvent struct {
+	EventKey   string              `json:"eventKey"`
+	Date       string              `json:"date"`
+	Actor      BitBucketUser       `json:"actor"`
+	Repository BitBucketRepository `json:"repository"`
+}
+
+// repo:forked, Repository is the new fork
+type BitBucketRepoForkedEvent struct {
+	BitBucketRepoEvent
+}
+
+// repo:modified, sent on rename or move to other project
+type BitBucketRepoModifiedEvent struct {
+	BitBucketRepoEvent
+	Old BitBucketRepository `json:"old"`
+	New BitBucketRepository `json:"new"`
+}
+
+// repo:comment:added, repo:comment:edited, repo:comment:deleted
+type BitBucketCommitCommentEvent struct {
+	BitBucketRepoEvent
+	Comment         BitBucketComment `json:"comment"`
+	CommentParentID int              `json:"commentParentId"`
+	Commit          string           `json:"commit"`
+}
+
+// mirror:repo_synchronized
+type BitBucketMirrorSyncEvent struct {
+	BitBucketRepoEvent
+	MirrorServer struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"mirrorServer"`
+	SyncType         string               `json:"syncType"`
+	RefLimitExceeded bool                 `json:"refLimitExceeded"`
+	Changes          []BitBucketRefChange `json:"changes"`
+}
+
+// Parse BitBucket repository lifecycle event json payload, maps data to build Teams notification webhook json,
+// unknown eventKey produces generic card
+func ParseRepoEvent(eventKey string, eventJson []byte) ([]byte, error) {
+	var bodyText string
+	var entities ReviewerEntitiesList
+	var actorText string
+	var unmarshalErr error
+	switch eventKey {
+	case "repo:forked":
+		var inventory BitBucketRepoForkedEvent
+		if unmarshalErr = json.Unmarshal(eventJson, &inventory); unmarshalErr != nil {
+			break
+		}
+		actorText = actorMention(inventory.Actor, &entities)
+		originText := "a repository"
+		if inventory.Repository.Origin != nil {
+			originText = inventory.Repository.Origin.markdownLink()
+		}
+		bodyText = fmt.Sprintf("Hi Team, %s forked %s as %s \n\n", actorText, originText, inventory.Repository.markdownLink())
+	case "repo:modified":
+		var inventory BitBucketRepoModifiedEvent
+		if unmarshalErr = json.Unmarshal(eventJson, &inventory); unmarshalErr != nil {
+			break
+		}
+		actorText = actorMention(inventory.Actor, &entities)
+		bodyText = fmt.Sprintf("Hi Team, %s modified repository %s \n\n", actorText, inventory.New.markdownLink())
+		if inventory.Old.Project.Key != inventory.New.Project.Key {
+			bodyText += fmt.Sprintf("Moved from project %s to %s \n\n", inventory.Old.Project.Key, inventory.New.Project.Key)
+		}
+		if inventory.Old.Name != inventory.New.Name {
+			bodyText += fmt.Sprintf("Renamed from %s to %s \n\n", inventory.Old.Name, inventory.New.Name)
+		}
+	case "repo:comment:added", "repo:comment:edited", "repo:comment:deleted":
+		var inventory BitBucketCommitCommentEvent
+		if unmarshalErr = json.Unmarshal(eventJson, &inventory); unmarshalErr != nil {
+			break
+		}
+		actorText = actorMention(inventory.Actor, &entities)
+		var action string
+		switch eventKey {
+		case "repo:comment:added":
+			action = "commented on commit"
+			if inventory.CommentParentID != 0 {
+				action = "replied to a comment on commit"
+			}
+		case "repo:comment:edited":
+			action = "edited a comment on commit"
+		default:
+			action = "deleted a comment on commit"
+		}
+		bodyText = fmt.Sprintf("Hi Team, %s %s `%s` in %s \n\n", actorText, action, shortHash(inventory.Commit), inventory.Repository.markdownLink())
+		if inventory.Comment.Text != "" && eventKey != "repo:comment:deleted" {
+			bodyText += fmt.Sprintf("> %s \n\n", inventory.Comment.Text)
+		}
+	case "mirror:repo_synchronized":
+		var inventory BitBucketMirrorSyncEvent
+		if unmarshalErr = json.Unmarshal(eventJson, &inventory); unmarshalErr != nil {
+			break
+		}
+		bodyText = fmt.Sprintf("Hi Team, mirror %s synchronized repository %s (%s sync, %d refs changed) \n\n",
+			inventory.MirrorServer.Name, inventory.Repository.markdownLink(), strings.ToLower(inventory.SyncType), len(inventory.Changes))
+		if inventory.RefLimitExceeded {
+			bodyText += "Ref limit exceeded, not all changes were listed \n\n"
+		}
+	default:
+		var inventory BitBucketRepoEvent
+		if unmarshalErr = json.Unmarshal(eventJson, &inventory); unmarshalErr != nil {
+			break
+		}
+		actorText = actorMention(inventory.Actor, &entities)
+		repoText := "unknown repository"
+		if inventory.Repository.Slug != "" {
+			repoText = inventory.Repository.markdownLink()
+		}
+		bodyText = fmt.Sprintf("Hi Team, event %s in repo %s by %s \n\n", eventKey, repoText, actorText)
+	}
+	if unmarshalErr != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling payload JSON : %s", unmarshalErr.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "bodyText : %s \n", bodyText)
+
+	return BuildTeamsMsg(bodyText, entities)
+}
+
+// Adds actor to mentions and returns mention text, system events have no actor
+func actorMention(actor BitBucketUser, entities *ReviewerEntitiesList) string {
+	if actor.Name == "" {
+		return "somebody"
+	}
+	entity := bitBucketMention(actor)
+	entities.Add(entity)
+	return entity.Text
+}
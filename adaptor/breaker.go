@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// Circuit breaker per destination webhook: circuit opens after threshold consecutive failed deliveries,
+// while open deliveries fail fast without calling Teams, after openDuration one probe delivery is let through
+// (half-open), its success closes the circuit and failure opens it again
+type circuitBreaker struct {
+	mu           sync.Mutex
+	threshold    int
+	openDuration time.Duration
+	circuits     map[string]*circuit // only destinations with failures are tracked
+}
+
+type circuit struct {
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Breaker with threshold <= 0 never opens
+func newCircuitBreaker(threshold int, openDuration time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, openDuration: openDuration, circuits: make(map[string]*circuit)}
+}
+
+// Returns error wrapping ErrCircuitOpen if delivery to destination must fail fast
+func (b *circuitBreaker) Allow(destination string, now time.Time) error {
+	if b.threshold <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.circuits[destination]
+	if !ok || !state.open {
+		return nil
+	}
+	retryIn := state.openedAt.Add(b.openDuration).Sub(now)
+	if retryIn <= 0 && !state.probing {
+		state.probing = true // half-open, this delivery is the probe
+		rlog.Infof("Circuit breaker of %s is half-open, probing", Redact(destination))
+		return nil
+	}
+	if retryIn < 0 {
+		retryIn = 0
+	}
+	return fmt.Errorf("%w for %s after %d consecutive failures, next probe in %s", ErrCircuitOpen, Redact(destination), state.failures, retryIn.Round(time.Second))
+}
+
+// Record delivery result of destination
+func (b *circuitBreaker) Record(destination string, success bool, now time.Time) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state, ok := b.circuits[destination]
+	if success {
+		if ok && state.open {
+			rlog.Infof("Circuit breaker of %s is closed", Redact(destination))
+		}
+		delete(b.circuits, destination)
+		return
+	}
+	if !ok {
+		state = &circuit{}
+		b.circuits[destination] = state
+	}
+	state.failures++
+	if state.probing || (!state.open && state.failures >= b.threshold) {
+		state.open = true
+		state.openedAt = now
+		state.probing = false
+		rlog.Errorf("Circuit breaker of %s is open after %d consecutive failures", Redact(destination), state.failures)
+	}
+}
+
+type circuitState struct {
+	Destination string     `json:"destination"`
+	State       string     `json:"state"`
+	Failures    int        `json:"failures"`
+	OpenedAt    *time.Time `json:"openedAt,omitempty"`
+}
+
+// Snapshot of destinations with failures, destinations are redacted
+func (b *circuitBreaker) State(now time.Time) []circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	states := []circuitState{}
+	for destination, state := range b.circuits {
+		entry := circuitState{Destination: Redact(destination), State: "closed", Failures: state.failures}
+		if state.open {
+			openedAt := state.openedAt
+			entry.OpenedAt = &openedAt
+			entry.State = "open"
+			if state.probing || now.Sub(state.openedAt) >= b.openDuration {
+				entry.State = "half-open"
+			}
+		}
+		states = append(states, entry)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Failures > states[j].Failures
+	})
+	return states
+}
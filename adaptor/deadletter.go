@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/romana/rlog"
+)
+
+// Failed notifications are kept in dead letter directory as JSON files with rendered card, error and attempt history,
+// so they can be replayed after Teams outage. Destination is stored redacted for display and AES-GCM encrypted
+// with key derived from DEAD_LETTER_KEY for replay, without the key dead letters can't be replayed.
+type deadLetter struct {
+	ID                   string            `json:"id"`
+	RequestID            string            `json:"requestId"`
+	Destination          string            `json:"destination"`
+	EncryptedDestination []byte            `json:"encryptedDestination,omitempty"`
+	Payload              json.RawMessage   `json:"payload"`
+	Error                string            `json:"error"`
+	Attempts             []deliveryAttempt `json:"attempts"`
+	FailedAt             time.Time         `json:"failedAt"`
+}
+
+type deadLetterSummary struct {
+	ID          string    `json:"id"`
+	RequestID   string    `json:"requestId"`
+	Destination string    `json:"destination"`
+	Error       string    `json:"error"`
+	Attempts    int       `json:"attempts"`
+	FailedAt    time.Time `json:"failedAt"`
+	Replayable  bool      `json:"replayable"`
+}
+
+type deadLetterStore struct {
+	dir     string
+	aead    cipher.AEAD   // nil if DEAD_LETTER_KEY is not set
+	max     int           // oldest dead letters above this count are dropped
+	ttl     time.Duration // older dead letters are dropped, 0 keeps them until max is reached
+	mu      sync.Mutex    // serializes replays and updates
+	pruneMu sync.Mutex    // serializes retention pruning
+}
+
+var deadLetterIDRegexp = regexp.MustCompile(`^[0-9]+-[0-9a-f]+$`)
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+var ErrNotReplayable = errors.New("dead letter can't be replayed")
+
+func newDeadLetterStore(dir string, key string, max int, ttl time.Duration) (*deadLetterStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	s := &deadLetterStore{dir: dir, max: max, ttl: ttl}
+	if key != "" {
+		keyHash := sha256.Sum256([]byte(key))
+		block, err := aes.NewCipher(keyHash[:])
+		if err != nil {
+			return nil, err
+		}
+		s.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *deadLetterStore) file(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *deadLetterStore) encrypt(plain string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, []byte(plain), nil), nil
+}
+
+func (s *deadLetterStore) decrypt(sealed []byte) (string, error) {
+	if len(sealed) < s.aead.NonceSize() {
+		return "", errors.New("encrypted destination is too short")
+	}
+	nonce, ciphertext := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (s *deadLetterStore) save(letter *deadLetter) error {
+	content, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(s.dir, letter.ID+".json", content)
+}
+
+// Persist failed notification, store errors are logged only as notification is lost anyway
+func (s *deadLetterStore) Add(requestID string, teamsURI string, payload []byte, failure error, attempts []deliveryAttempt) {
+	if s == nil {
+		return
+	}
+	now := time.Now().UTC()
+	id, err := newRecordID(now)
+	if err != nil {
+		rlog.Errorf("Can't store dead letter of request %s: %s", requestID, err)
+		return
+	}
+	letter := &deadLetter{
+		ID:          id,
+		RequestID:   requestID,
+		Destination: Redact(teamsURI),
+		Payload:     payload,
+		Error:       Redact(failure.Error()),
+		Attempts:    attempts,
+		FailedAt:    now,
+	}
+	if s.aead != nil {
+		if letter.EncryptedDestination, err = s.encrypt(teamsURI); err != nil {
+			rlog.Errorf("Can't store dead letter of request %s: %s", requestID, err)
+			return
+		}
+	}
+	// prune before write, so store never holds more than max dead letters
+	s.prune(now, 1)
+	if err := s.save(letter); err != nil {
+		rlog.Errorf("Can't store dead letter of request %s: %s", requestID, err)
+		return
+	}
+	rlog.Infof("Failed notification of request %s is stored as dead letter %s", requestID, id)
+}
+
+// Sorted IDs of stored dead letters, oldest first
+func (s *deadLetterStore) ids() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Drop dead letters older than ttl and oldest ones so that reserve more fit under max,
+// ID starts with creation time in nanoseconds, so files are not read
+func (s *deadLetterStore) prune(now time.Time, reserve int) {
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+	ids, err := s.ids()
+	if err != nil {
+		rlog.Errorf("Can't list DEAD_LETTER_DIR: %s", err)
+		return
+	}
+	var dropped int
+	for i, id := range ids {
+		createdNanos, _, _ := strings.Cut(id, "-")
+		created, parseErr := strconv.ParseInt(createdNanos, 10, 64)
+		expired := s.ttl > 0 && parseErr == nil && now.Sub(time.Unix(0, created)) > s.ttl
+		overflow := s.max > 0 && len(ids)-i+reserve > s.max
+		if !expired && !overflow {
+			break
+		}
+		if err := s.Remove(id); err != nil && !errors.Is(err, ErrDeadLetterNotFound) {
+			rlog.Errorf("Can't drop dead letter %s: %s", id, err)
+			continue
+		}
+		dropped++
+	}
+	if dropped > 0 {
+		rlog.Infof("Dropped %d dead letters by DEAD_LETTER_MAX/DEAD_LETTER_TTL retention", dropped)
+	}
+}
+
+func (s *deadLetterStore) Get(id string) (*deadLetter, error) {
+	if !deadLetterIDRegexp.MatchString(id) {
+		return nil, ErrDeadLetterNotFound
+	}
+	content, err := os.ReadFile(s.file(id))
+	if os.IsNotExist(err) {
+		return nil, ErrDeadLetterNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	var letter deadLetter
+	if err := json.Unmarshal(content, &letter); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// Summaries of all dead letters, oldest first
+func (s *deadLetterStore) List() ([]deadLetterSummary, error) {
+	s.prune(time.Now(), 0)
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	summaries := []deadLetterSummary{}
+	for _, id := range ids {
+		letter, err := s.Get(id)
+		if err != nil {
+			rlog.Errorf("Can't read dead letter %s: %s", id, err)
+			continue
+		}
+		summaries = append(summaries, deadLetterSummary{
+			ID:          letter.ID,
+			RequestID:   letter.RequestID,
+			Destination: letter.Destination,
+			Error:       letter.Error,
+			Attempts:    len(letter.Attempts),
+			FailedAt:    letter.FailedAt,
+			Replayable:  len(letter.EncryptedDestination) > 0,
+		})
+	}
+	return summaries, nil
+}
+
+func (s *deadLetterStore) Remove(id string) error {
+	if !deadLetterIDRegexp.MatchString(id) {
+		return ErrDeadLetterNotFound
+	}
+	err := os.Remove(s.file(id))
+	if os.IsNotExist(err) {
+		return ErrDeadLetterNotFound
+	}
+	return err
+}
+
+// Remove all dead letters, returns number of removed ones
+func (s *deadLetterStore) Purge() (int, error) {
+	summaries, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	var removed int
+	for _, summary := range summaries {
+		if err := s.Remove(summary.ID); err != nil && !errors.Is(err, ErrDeadLetterNotFound) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Deliver dead letter again, it is removed on success, otherwise attempts and error are updated
+func (s *deadLetterStore) Replay(id string, deliver func(teamsURI string, requestID string, payload []byte) deliveryResult) (deliveryResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	letter, err := s.Get(id)
+	if err != nil {
+		return deliveryResult{}, err
+	}
+	if s.aead == nil || len(letter.EncryptedDestination) == 0 {
+		return deliveryResult{}, fmt.Errorf("%w: destination is stored redacted only, set DEAD_LETTER_KEY to replay new dead letters", ErrNotReplayable)
+	}
+	teamsURI, err := s.decrypt(letter.EncryptedDestination)
+	if err != nil {
+		return deliveryResult{}, fmt.Errorf("%w: can't decrypt destination, DEAD_LETTER_KEY was changed? %s", ErrNotReplayable, err)
+	}
+	result := deliver(teamsURI, letter.RequestID, letter.Payload)
+	if failure := result.Failure(letter.RequestID); failure != nil {
+		letter.Attempts = append(letter.Attempts, result.Attempts...)
+		letter.Error = Redact(failure.Error())
+		if err := s.save(letter); err != nil {
+			rlog.Errorf("Can't update dead letter %s: %s", id, err)
+		}
+		return result, nil
+	}
+	rlog.Infof("Dead letter %s of request %s is replayed", id, letter.RequestID)
+	return result, s.Remove(id)
+}
+
+// Admin API on health port: list, inspect, replay and purge dead letters,
+// requests must carry "Authorization: Bearer <ADMIN_TOKEN>", API is not registered without token
+func (s *deadLetterStore) RegisterAdmin(app *fiber.App, adminToken string, deliver func(teamsURI string, requestID string, payload []byte) deliveryResult) {
+	if adminToken == "" {
+		rlog.Info("ADMIN_TOKEN is not set, dead letter admin API on port 9000 is disabled")
+		return
+	}
+	admin := app.Group("/deadletters", func(c *fiber.Ctx) error {
+		if subtle.ConstantTimeCompare([]byte(c.Get(fiber.HeaderAuthorization)), []byte("Bearer "+adminToken)) != 1 {
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(401).SendString("Error: ADMIN_TOKEN bearer token is required")
+		}
+		return c.Next()
+	})
+	sendError := func(c *fiber.Ctx, err error) error {
+		code := 500
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			code = 404
+		}
+		errMsg := err.Error()
+		rlog.Error(errMsg)
+		c.Set("Content-Type", "text/plain; charset=utf-8")
+		return c.Status(code).SendString("Error: " + Redact(errMsg))
+	}
+	// GET /deadletters
+	admin.Get("/", func(c *fiber.Ctx) error {
+		summaries, err := s.List()
+		if err != nil {
+			return sendError(c, err)
+		}
+		return c.JSON(summaries)
+	})
+	// GET /deadletters/:id
+	admin.Get("/:id", func(c *fiber.Ctx) error {
+		letter, err := s.Get(c.Params("id"))
+		if err != nil {
+			return sendError(c, err)
+		}
+		letter.EncryptedDestination = nil
+		return c.JSON(letter)
+	})
+	// POST /deadletters/:id/replay
+	admin.Post("/:id/replay", func(c *fiber.Ctx) error {
+		result, err := s.Replay(c.Params("id"), deliver)
+		if errors.Is(err, ErrDeadLetterNotFound) {
+			return sendError(c, err)
+		} else if errors.Is(err, ErrNotReplayable) {
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(409).SendString("Error: " + Redact(err.Error()))
+		} else if err != nil {
+			return sendError(c, err)
+		}
+		status := "replayed"
+		if result.Failure("") != nil {
+			status = "failed"
+		}
+		return c.JSON(fiber.Map{"status": status, "code": result.Code, "attempts": result.Attempts})
+	})
+	// DELETE /deadletters/:id
+	admin.Delete("/:id", func(c *fiber.Ctx) error {
+		if err := s.Remove(c.Params("id")); err != nil {
+			return sendError(c, err)
+		}
+		return c.SendStatus(204)
+	})
+	// DELETE /deadletters
+	admin.Delete("/", func(c *fiber.Ctx) error {
+		removed, err := s.Purge()
+		if err != nil {
+			return sendError(c, err)
+		}
+		return c.JSON(fiber.Map{"purged": removed})
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// BitBucket retries deliveries and admins can resend them, duplicates seen within window are acknowledged but not forwarded.
+// Delivery is identified by X-Request-Id header and by payload identity, either of them seen before makes it a duplicate.
+type deliveryCache struct {
+	mu        sync.Mutex
+	window    time.Duration
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+var seenDeliveries = &deliveryCache{seen: make(map[string]time.Time)}
+
+// Returns false if any of keys was seen within window, otherwise remembers all keys
+func (d *deliveryCache) Reserve(keys []string, now time.Time) bool {
+	if d.window <= 0 {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.lastPurge) > d.window {
+		for key, seenAt := range d.seen {
+			if now.Sub(seenAt) > d.window {
+				delete(d.seen, key)
+			}
+		}
+		d.lastPurge = now
+	}
+	for _, key := range keys {
+		if seenAt, ok := d.seen[key]; ok && now.Sub(seenAt) <= d.window {
+			return false
+		}
+	}
+	for _, key := range keys {
+		d.seen[key] = now
+	}
+	return true
+}
+
+// Forget keys of delivery which wasn't forwarded, so retry of it is not suppressed
+func (d *deliveryCache) Forget(keys []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range keys {
+		delete(d.seen, key)
+	}
+}
+
+type deliveryIdentity struct {
+	EventKey string `json:"eventKey"`
+	Date     string `json:"date"`
+	Actor    struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+	PullRequest struct {
+		ID      int `json:"id"`
+		Version int `json:"version"`
+	} `json:"pullRequest"`
+	Comment *struct {
+		ID      int `json:"id"`
+		Version int `json:"version"`
+	} `json:"comment"`
+	Participant *struct {
+		User struct {
+			Name string `json:"name"`
+		} `json:"user"`
+	} `json:"participant"`
+}
+
+// Delivery keys scoped by route: X-Request-Id if present and payload identity, which is
+// eventKey + PR id + PR version + date + actor + comment id and version + participant for BitBucket PR events
+// (comments and reviews don't bump PR version) or hash of the whole body otherwise, as other events
+// of the same kind within a second (date has 1s resolution) differ only in their details
+func deliveryKeys(route string, requestID string, body []byte) []string {
+	var keys []string
+	if requestID != "" {
+		keys = append(keys, fmt.Sprintf("%x", sha256.Sum256([]byte(route+"|rid|"+requestID))))
+	}
+	var identity deliveryIdentity
+	if err := json.Unmarshal(body, &identity); err == nil && strings.HasPrefix(identity.EventKey, "pr:") && identity.Date != "" {
+		payloadID := fmt.Sprintf("%s|%d|%d|%s|%s", identity.EventKey, identity.PullRequest.ID, identity.PullRequest.Version, identity.Date, identity.Actor.Name)
+		if identity.Comment != nil {
+			payloadID += fmt.Sprintf("|comment|%d|%d", identity.Comment.ID, identity.Comment.Version)
+		}
+		if identity.Participant != nil {
+			payloadID += "|participant|" + identity.Participant.User.Name
+		}
+		keys = append(keys, fmt.Sprintf("%x", sha256.Sum256([]byte(route+"|event|"+payloadID))))
+	} else {
+		bodyHash := sha256.Sum256(body)
+		keys = append(keys, fmt.Sprintf("%x", sha256.Sum256([]byte(route+"|body|"+string(bodyHash[:])))))
+	}
+	return keys
+}
+
+// BitBucket date format is "2017-09-19T09:58:11+1000", RFC3339 is accepted too
+func parseEventDate(date string) (time.Time, error) {
+	eventDate, err := time.Parse("2006-01-02T15:04:05-0700", date)
+	if err != nil {
+		eventDate, err = time.Parse(time.RFC3339, date)
+	}
+	return eventDate, err
+}
+
+// Signed payload date is covered by signature, so payload older than maxAge is a replay of captured request
+func checkEventAge(body []byte, maxAge time.Duration, now time.Time) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	var identity deliveryIdentity
+	if err := json.Unmarshal(body, &identity); err != nil || identity.Date == "" {
+		return nil // payloads without date (pings, other sources) can't be checked
+	}
+	eventDate, err := parseEventDate(identity.Date)
+	if err != nil {
+		return fmt.Errorf("%w: malformed event date: %s", ErrUnauthorized, identity.Date)
+	}
+	if age := now.Sub(eventDate); age > maxAge {
+		return fmt.Errorf("%w: event date %s is older than %s", ErrUnauthorized, identity.Date, maxAge)
+	}
+	return nil
+}
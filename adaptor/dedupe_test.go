@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveryKeysDistinguishEventsOfSameSecond(t *testing.T) {
+	for name, bodies := range map[string][2]string{
+		"build statuses of different commits": {
+			`{"eventKey":"repo:commit_status_updated","date":"2026-10-18T10:00:00+0000","commit":"aaaa","status":{"state":"FAILED"}}`,
+			`{"eventKey":"repo:commit_status_updated","date":"2026-10-18T10:00:00+0000","commit":"bbbb","status":{"state":"FAILED"}}`,
+		},
+		"comments on same PR version": {
+			`{"eventKey":"pr:comment:added","date":"2026-10-18T10:00:00+0000","actor":{"name":"jdoe"},"pullRequest":{"id":7,"version":2},"comment":{"id":11,"version":0}}`,
+			`{"eventKey":"pr:comment:added","date":"2026-10-18T10:00:00+0000","actor":{"name":"jdoe"},"pullRequest":{"id":7,"version":2},"comment":{"id":12,"version":0}}`,
+		},
+		"approvals by different reviewers": {
+			`{"eventKey":"pr:reviewer:approved","date":"2026-10-18T10:00:00+0000","actor":{"name":"alice"},"pullRequest":{"id":7,"version":2},"participant":{"user":{"name":"alice"}}}`,
+			`{"eventKey":"pr:reviewer:approved","date":"2026-10-18T10:00:00+0000","actor":{"name":"bob"},"pullRequest":{"id":7,"version":2},"participant":{"user":{"name":"bob"}}}`,
+		},
+	} {
+		cache := &deliveryCache{window: time.Minute, seen: make(map[string]time.Time)}
+		now := time.Now()
+		if !cache.Reserve(deliveryKeys("route", "", []byte(bodies[0])), now) {
+			t.Fatalf("%s: first event reported as duplicate", name)
+		}
+		if !cache.Reserve(deliveryKeys("route", "", []byte(bodies[1])), now) {
+			t.Errorf("%s: second event reported as duplicate", name)
+		}
+	}
+}
+
+func TestDeliveryKeysDetectRetries(t *testing.T) {
+	cache := &deliveryCache{window: time.Minute, seen: make(map[string]time.Time)}
+	now := time.Now()
+	event := `{"eventKey":"pr:opened","date":"2026-10-18T10:00:00+0000","actor":{"name":"jdoe"},"pullRequest":{"id":7,"version":0}}`
+	if !cache.Reserve(deliveryKeys("route", "req-1", []byte(event)), now) {
+		t.Fatal("first delivery reported as duplicate")
+	}
+	// BitBucket retry reuses X-Request-Id, manual resend reuses the payload
+	reformatted := `{"date":"2026-10-18T10:00:00+0000", "eventKey":"pr:opened","actor":{"name":"jdoe"},"pullRequest":{"id":7,"version":0}}`
+	if cache.Reserve(deliveryKeys("route", "", []byte(reformatted)), now) {
+		t.Error("resend of the same PR event not detected")
+	}
+	if cache.Reserve(deliveryKeys("route", "req-1", []byte(`{"test":true}`)), now) {
+		t.Error("retry with the same X-Request-Id not detected")
+	}
+	if !cache.Reserve(deliveryKeys("other-route", "req-1", []byte(event)), now) {
+		t.Error("delivery to other route reported as duplicate")
+	}
+	if !cache.Reserve(deliveryKeys("route", "", []byte(event)), now.Add(2*time.Minute)) {
+		t.Error("delivery after window reported as duplicate")
+	}
+}
+
+func TestCheckEventAge(t *testing.T) {
+	now := time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+	for body, stale := range map[string]bool{
+		`{"date":"2026-10-18T09:30:00+0000"}`: false,
+		`{"date":"2026-10-18T08:00:00+0000"}`: true,
+		`{"date":"2026-10-18T08:00:00Z"}`:     true,
+		`{"date":"yesterday"}`:                true,
+		`{"test":true}`:                       false,
+	} {
+		if err := checkEventAge([]byte(body), time.Hour, now); (err != nil) != stale {
+			t.Errorf("checkEventAge(%s) = %v, want stale %t", body, err, stale)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/romana/rlog"
+	"github.com/valyala/fasthttp"
+)
+
+// Retry policy of Teams API call: attempts in total, delay before attempt n is baseDelay * 2^(n-2)
+// capped by maxDelay with jitter, Retry-After of Teams response is used instead when present
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+type deliveryAttempt struct {
+	At    time.Time `json:"at"`
+	Code  int       `json:"code,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type deliveryResult struct {
+	Code     int // 0 if Teams didn't answer
+	Body     []byte
+	Err      error
+	Attempts []deliveryAttempt
+}
+
+// Teams didn't process the card for these codes, so posting it again can't produce duplicate
+func isRetryableCode(code int) bool {
+	return code == 429 || code == 502 || code == 503 // 504 is not retried, Teams may have posted the card after gateway timed out
+}
+
+// Only errors before request was sent are retried, after timeout or closed connection Teams may have posted the card
+func isRetryableError(err error) bool {
+	if errors.Is(err, fasthttp.ErrDialTimeout) || errors.Is(err, fasthttp.ErrTLSHandshakeTimeout) || errors.Is(err, fasthttp.ErrNoFreeConns) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// Retry-After is either delay in seconds or HTTP date
+func parseRetryAfter(value []byte, now time.Time) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(string(value)); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := fasthttp.ParseHTTPDate(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Exponential backoff with jitter, delay is random in [d/2, d]
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Post card to Teams with retries, every attempt is logged with request id
+func deliverToTeams(client *fasthttp.Client, teamsURI string, requestID string, payload []byte, policy retryPolicy) deliveryResult {
+	var result deliveryResult
+	attempts := policy.attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; attempt <= attempts; attempt++ {
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		req.SetRequestURI(teamsURI)
+		req.Header.SetMethod("POST")
+		req.Header.Add("X-Request-Id", requestID)
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBody(payload)
+		err := client.Do(req, resp) // sending request to Teams host
+		now := time.Now()
+		record := deliveryAttempt{At: now}
+		result.Err = err
+		result.Code = 0
+		var retryAfter time.Duration
+		var hasRetryAfter bool
+		if err != nil {
+			record.Error = Redact(err.Error())
+			rlog.Errorf("Teams API request (%s) attempt %d/%d reported error: %s", requestID, attempt, attempts, err)
+		} else {
+			result.Code = resp.StatusCode()
+			result.Body = append([]byte(nil), resp.Body()...)
+			record.Code = result.Code
+			retryAfter, hasRetryAfter = parseRetryAfter(resp.Header.Peek(fasthttp.HeaderRetryAfter), now)
+			rlog.Infof("Notification sent to Teams, request Id: %s ; attempt %d/%d ; result code:%d", requestID, attempt, attempts, result.Code)
+			rlog.Debugf("Notification response body: %s", result.Body)
+			if respContType := resp.Header.ContentType(); respContType != nil {
+				rlog.Debugf("Notification response header contentType: %s", respContType)
+			}
+			if respEnc := resp.Header.ContentEncoding(); respEnc != nil {
+				rlog.Debugf("Notification response header contentEncoding: %s", respEnc)
+			}
+		}
+		fasthttp.ReleaseRequest(req)
+		fasthttp.ReleaseResponse(resp)
+		result.Attempts = append(result.Attempts, record)
+
+		retryable := (err != nil && isRetryableError(err)) || (err == nil && isRetryableCode(result.Code))
+		if !retryable || attempt == attempts {
+			break
+		}
+		delay := policy.backoff(attempt)
+		if hasRetryAfter {
+			if retryAfter > policy.maxDelay {
+				rlog.Errorf("Teams API request (%s) Retry-After %s is longer than max retry delay %s, giving up", requestID, retryAfter, policy.maxDelay)
+				break
+			}
+			delay = retryAfter
+		}
+		rlog.Infof("Teams API request (%s) attempt %d/%d will be retried in %s", requestID, attempt+1, attempts, delay)
+		time.Sleep(delay)
+	}
+	return result
+}
+
+// Error describing failed delivery, nil if Teams accepted the card
+func (r deliveryResult) Failure(requestID string) error {
+	if r.Err != nil {
+		return fmt.Errorf("Teams API request (%s) reported error: %w", requestID, r.Err)
+	}
+	if r.Code >= 400 {
+		return fmt.Errorf("Teams API request (%s) failed with HTTP code: %d", requestID, r.Code)
+	}
+	return nil
+}
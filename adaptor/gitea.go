@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// Gitea and Forgejo send event name in X-Gitea-Event (Forgejo also in X-Forgejo-Event) header,
+// payload is signed with HMAC-SHA256 hex digest in X-Gitea-Signature header
+// https://docs.gitea.com/usage/webhooks
+
+// Secret configured in Gitea webhook, signature check is disabled when empty
+var giteaSecret string
+
+type GiteaUser struct {
+	ID       int    `json:"id"`
+	Login    string `json:"login"`
+	Username string `json:"username"`
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+}
+
+type GiteaRef struct {
+	Label string `json:"label"`
+	Ref   string `json:"ref"`
+	SHA   string `json:"sha"`
+	Repo  struct {
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+	} `json:"repo"`
+}
+
+type GiteaPREvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		ID                 int         `json:"id"`
+		Number             int         `json:"number"`
+		User               GiteaUser   `json:"user"`
+		Title              string      `json:"title"`
+		Body               string      `json:"body"`
+		State              string      `json:"state"`
+		HTMLURL            string      `json:"html_url"`
+		Merged             bool        `json:"merged"`
+		MergedBy           *GiteaUser  `json:"merged_by"`
+		Head               GiteaRef    `json:"head"`
+		Base               GiteaRef    `json:"base"`
+		Assignees          []GiteaUser `json:"assignees"`
+		RequestedReviewers []GiteaUser `json:"requested_reviewers"`
+	} `json:"pull_request"`
+	RequestedReviewer *GiteaUser `json:"requested_reviewer"`
+	Sender            GiteaUser  `json:"sender"`
+	Changes           *struct {
+		Title *struct {
+			From string `json:"from"`
+		} `json:"title"`
+		Body *struct {
+			From string `json:"from"`
+		} `json:"body"`
+	} `json:"changes"`
+	// pull_request_approved, pull_request_rejected, pull_request_comment
+	Review *struct {
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	} `json:"review"`
+}
+
+// Request came from Gitea or Forgejo if X-Gitea-Event header is set, it must be checked before GitHub
+// as Gitea sends X-GitHub-Event header too
+func isGiteaEvent(giteaEvent string) bool {
+	return giteaEvent != ""
+}
+
+// Compare HMAC-SHA256 of body with X-Gitea-Signature header value in constant time
+func verifyGiteaSignature(signature string, body []byte) error {
+	if giteaSecret == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(giteaSecret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	actual, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || !hmac.Equal(actual, expected) {
+		rlog.Error("X-Gitea-Signature header is missing or doesn't match payload signed with configured GITEA_WEBHOOK_SECRET")
+		return fmt.Errorf("%w: X-Gitea-Signature verification failed", ErrUnauthorized)
+	}
+	return nil
+}
+
+// Gitea may hide emails ("login@noreply.example.org"), login is used as mention id then
+func (u GiteaUser) toBitBucketUser() BitBucketUser {
+	var user BitBucketUser
+	user.Name = u.Login
+	if user.Name == "" {
+		user.Name = u.Username
+	}
+	user.EmailAddress = u.Email
+	if !strings.Contains(user.EmailAddress, "@") || strings.Contains(user.EmailAddress, "@noreply.") {
+		user.EmailAddress = user.Name
+	}
+	user.DisplayName = u.FullName
+	if user.DisplayName == "" {
+		user.DisplayName = user.Name
+	}
+	user.ID = u.ID
+	user.Slug = user.Name
+	return user
+}
+
+func (r GiteaRef) toBitBucketRef() BitBucketRef {
+	var ref BitBucketRef
+	ref.ID = "refs/heads/" + r.Ref
+	ref.DisplayID = r.Ref
+	ref.LatestCommit = r.SHA
+	ref.Type = "BRANCH"
+	ref.Repository.Slug = r.Repo.Name
+	ref.Repository.Name = r.Repo.Name
+	return ref
+}
+
+// Maps Gitea event name and action to BitBucket Server eventKey with the same meaning, empty if event should not be sent
+func giteaEventKey(giteaEvent string, inventory GiteaPREvent) string {
+	switch giteaEvent {
+	case "pull_request":
+		switch inventory.Action {
+		case "opened", "reopened":
+			return "pr:opened"
+		case "synchronized":
+			return "pr:from_ref_updated"
+		case "edited":
+			return "pr:modified"
+		case "closed":
+			if inventory.PullRequest.Merged {
+				return "pr:merged"
+			}
+			return "pr:declined"
+		case "review_requested", "review_request_removed":
+			return "pr:reviewer:updated"
+		}
+	// reviews, X-Gitea-Event-Type of them is pull_request_review_*
+	case "pull_request_approved":
+		return "pr:reviewer:approved"
+	case "pull_request_rejected":
+		return "pr:reviewer:needs_work"
+	case "pull_request_comment":
+		return "pr:comment:added"
+	}
+	return ""
+}
+
+// Parse Gitea/Forgejo pull_request or review (pull_request_approved, pull_request_rejected, pull_request_comment) event json payload, maps data to build Teams notification webhook json
+func ParseGiteaPR(giteaEvent string, eventJson []byte) ([]byte, error) {
+	var inventory GiteaPREvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling Gitea payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "gitea inventory : %+v\n", inventory)
+
+	var event BitBucketPREvent
+	event.EventKey = giteaEventKey(giteaEvent, inventory)
+	if event.EventKey == "" {
+		rlog.Debugf("Gitea event %s with action '%s' is not routed", giteaEvent, inventory.Action)
+		return []byte(""), ErrEventIgnored
+	}
+	event.Actor = inventory.Sender.toBitBucketUser()
+
+	src := inventory.PullRequest
+	pr := &event.PullRequest
+	pr.ID = src.Number
+	pr.Title = src.Title
+	pr.Description = src.Body
+	pr.State = strings.ToUpper(src.State)
+	if src.Merged {
+		pr.State = "MERGED"
+	}
+	pr.Open = src.State == "open"
+	pr.Closed = !pr.Open
+	pr.FromRef = src.Head.toBitBucketRef()
+	pr.ToRef = src.Base.toBitBucketRef()
+	pr.Author.User = src.User.toBitBucketUser()
+	pr.Author.Role = "AUTHOR"
+	pr.Links.Self = append(pr.Links.Self, struct {
+		Href string `json:"href"`
+	}{Href: src.HTMLURL})
+	for _, val := range src.RequestedReviewers {
+		pr.Reviewers = append(pr.Reviewers, BitBucketParticipant{User: val.toBitBucketUser(), Role: "REVIEWER"})
+	}
+
+	switch inventory.Action {
+	case "edited":
+		if inventory.Changes != nil && inventory.Changes.Title != nil {
+			event.PreviousTitle = inventory.Changes.Title.From
+		}
+		if inventory.Changes != nil && inventory.Changes.Body != nil {
+			event.PreviousDescription = inventory.Changes.Body.From
+		}
+	case "review_requested":
+		if inventory.RequestedReviewer != nil {
+			event.AddedReviewers = append(event.AddedReviewers, inventory.RequestedReviewer.toBitBucketUser())
+		}
+	case "review_request_removed":
+		if inventory.RequestedReviewer != nil {
+			event.RemovedReviewers = append(event.RemovedReviewers, inventory.RequestedReviewer.toBitBucketUser())
+		}
+	}
+	if src.Merged && src.MergedBy != nil {
+		event.Actor = src.MergedBy.toBitBucketUser()
+	}
+	if inventory.Review != nil {
+		event.Participant = &BitBucketParticipant{User: event.Actor, Role: "REVIEWER"}
+		event.Comment = &BitBucketComment{Text: inventory.Review.Content, Author: event.Actor}
+	}
+
+	return RenderPR(event)
+}
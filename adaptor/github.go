@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// GitHub (and GitHub Enterprise) sends event name in X-GitHub-Event header, action is in payload
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads#pull_request
+
+type GitHubUser struct {
+	Login   string `json:"login"`
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Type    string `json:"type"`
+	HTMLURL string `json:"html_url"`
+}
+
+type GitHubRepository struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	HTMLURL  string `json:"html_url"`
+}
+
+type GitHubRef struct {
+	Label string           `json:"label"`
+	Ref   string           `json:"ref"`
+	SHA   string           `json:"sha"`
+	Repo  GitHubRepository `json:"repo"`
+}
+
+type GitHubPullRequest struct {
+	ID                 int          `json:"id"`
+	Number             int          `json:"number"`
+	Title              string       `json:"title"`
+	Body               string       `json:"body"`
+	State              string       `json:"state"`
+	Draft              bool         `json:"draft"`
+	Merged             bool         `json:"merged"`
+	HTMLURL            string       `json:"html_url"`
+	User               GitHubUser   `json:"user"`
+	Head               GitHubRef    `json:"head"`
+	Base               GitHubRef    `json:"base"`
+	RequestedReviewers []GitHubUser `json:"requested_reviewers"`
+	MergedBy           *GitHubUser  `json:"merged_by"`
+}
+
+type GitHubPREvent struct {
+	Action      string            `json:"action"`
+	Number      int               `json:"number"`
+	PullRequest GitHubPullRequest `json:"pull_request"`
+	Repository  GitHubRepository  `json:"repository"`
+	Sender      GitHubUser        `json:"sender"`
+	// review_requested, review_request_removed
+	RequestedReviewer *GitHubUser `json:"requested_reviewer"`
+	RequestedTeam     *struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+	} `json:"requested_team"`
+	// edited
+	Changes *struct {
+		Title *struct {
+			From string `json:"from"`
+		} `json:"title"`
+		Body *struct {
+			From string `json:"from"`
+		} `json:"body"`
+		Base *struct {
+			Ref struct {
+				From string `json:"from"`
+			} `json:"ref"`
+		} `json:"base"`
+	} `json:"changes"`
+	// pull_request_review
+	Review *struct {
+		ID      int        `json:"id"`
+		State   string     `json:"state"`
+		Body    string     `json:"body"`
+		User    GitHubUser `json:"user"`
+		HTMLURL string     `json:"html_url"`
+	} `json:"review"`
+}
+
+// Request came from GitHub if X-GitHub-Event header is set
+func isGitHubEvent(githubEvent string) bool {
+	return githubEvent != ""
+}
+
+// GitHub doesn't expose emails unless user made it public, login is used as mention id then
+func (u GitHubUser) toBitBucketUser() BitBucketUser {
+	var user BitBucketUser
+	user.Name = u.Login
+	user.EmailAddress = u.Email
+	if user.EmailAddress == "" {
+		user.EmailAddress = u.Login
+	}
+	user.DisplayName = u.Name
+	if user.DisplayName == "" {
+		user.DisplayName = u.Login
+	}
+	user.Slug = u.Login
+	user.Type = u.Type
+	return user
+}
+
+func (r GitHubRef) toBitBucketRef() BitBucketRef {
+	var ref BitBucketRef
+	ref.ID = "refs/heads/" + r.Ref
+	ref.DisplayID = r.Ref
+	ref.LatestCommit = r.SHA
+	ref.Type = "BRANCH"
+	ref.Repository.Slug = r.Repo.Name
+	ref.Repository.Name = r.Repo.Name
+	return ref
+}
+
+// Maps GitHub event name and action to BitBucket Server eventKey with the same meaning, empty if event should not be sent
+func gitHubEventKey(githubEvent string, inventory GitHubPREvent) string {
+	switch githubEvent {
+	case "pull_request":
+		switch inventory.Action {
+		case "opened", "reopened", "ready_for_review":
+			return "pr:opened"
+		case "synchronize":
+			return "pr:from_ref_updated"
+		case "edited":
+			return "pr:modified"
+		case "closed":
+			if inventory.PullRequest.Merged {
+				return "pr:merged"
+			}
+			return "pr:declined"
+		case "review_requested", "review_request_removed":
+			return "pr:reviewer:updated"
+		}
+	case "pull_request_review":
+		if inventory.Review == nil {
+			return ""
+		}
+		switch inventory.Action {
+		case "submitted":
+			switch strings.ToLower(inventory.Review.State) {
+			case "approved":
+				return "pr:reviewer:approved"
+			case "changes_requested":
+				return "pr:reviewer:needs_work"
+			case "commented":
+				return "pr:comment:added"
+			}
+		case "edited":
+			return "pr:comment:edited"
+		case "dismissed":
+			return "pr:reviewer:unapproved"
+		}
+	}
+	return ""
+}
+
+// Parse GitHub pull_request or pull_request_review event json payload, maps data to build Teams notification webhook json
+func ParseGitHubPR(githubEvent string, eventJson []byte) ([]byte, error) {
+	var inventory GitHubPREvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling GitHub payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "github inventory : %+v\n", inventory)
+
+	var event BitBucketPREvent
+	event.EventKey = gitHubEventKey(githubEvent, inventory)
+	if event.EventKey == "" {
+		rlog.Debugf("GitHub event %s with action '%s' is not routed", githubEvent, inventory.Action)
+		return []byte(""), ErrEventIgnored
+	}
+	event.Actor = inventory.Sender.toBitBucketUser()
+
+	src := inventory.PullRequest
+	pr := &event.PullRequest
+	pr.ID = src.Number
+	pr.Title = src.Title
+	pr.Description = src.Body
+	pr.State = strings.ToUpper(src.State)
+	if src.Merged {
+		pr.State = "MERGED"
+	}
+	pr.Open = src.State == "open"
+	pr.Closed = !pr.Open
+	pr.FromRef = src.Head.toBitBucketRef()
+	pr.ToRef = src.Base.toBitBucketRef()
+	pr.Author.User = src.User.toBitBucketUser()
+	pr.Author.Role = "AUTHOR"
+	pr.Links.Self = append(pr.Links.Self, struct {
+		Href string `json:"href"`
+	}{Href: src.HTMLURL})
+	for _, val := range src.RequestedReviewers {
+		pr.Reviewers = append(pr.Reviewers, BitBucketParticipant{User: val.toBitBucketUser(), Role: "REVIEWER"})
+	}
+
+	switch inventory.Action {
+	case "edited":
+		if inventory.Changes != nil && inventory.Changes.Title != nil {
+			event.PreviousTitle = inventory.Changes.Title.From
+		}
+		if inventory.Changes != nil && inventory.Changes.Body != nil {
+			event.PreviousDescription = inventory.Changes.Body.From
+		}
+		if inventory.Changes != nil && inventory.Changes.Base != nil {
+			previousTarget := pr.ToRef
+			previousTarget.ID = "refs/heads/" + inventory.Changes.Base.Ref.From
+			previousTarget.DisplayID = inventory.Changes.Base.Ref.From
+			event.PreviousTarget = &previousTarget
+		}
+	case "review_requested", "review_request_removed":
+		var reviewers []BitBucketUser
+		if inventory.RequestedReviewer != nil {
+			reviewers = append(reviewers, inventory.RequestedReviewer.toBitBucketUser())
+		}
+		if inventory.RequestedTeam != nil {
+			// teams can't be mentioned by login, displayed by name only
+			reviewers = append(reviewers, BitBucketUser{Name: inventory.RequestedTeam.Slug, DisplayName: inventory.RequestedTeam.Name})
+		}
+		if inventory.Action == "review_requested" {
+			event.AddedReviewers = reviewers
+		} else {
+			event.RemovedReviewers = reviewers
+		}
+	}
+	if src.Merged && src.MergedBy != nil {
+		event.Actor = src.MergedBy.toBitBucketUser()
+	}
+	if inventory.Review != nil {
+		event.Participant = &BitBucketParticipant{User: inventory.Review.User.toBitBucketUser(), Role: "REVIEWER"}
+		event.Comment = &BitBucketComment{
+			ID:     inventory.Review.ID,
+			Text:   inventory.Review.Body,
+			Author: inventory.Review.User.toBitBucketUser(),
+		}
+	}
+
+	return RenderPR(event)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// GitLab sends hook name in X-Gitlab-Event header and configured secret token in X-Gitlab-Token header
+// https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#merge-request-events
+
+// Shared secret expected in X-Gitlab-Token header, check is disabled when empty
+var gitlabToken string
+
+type GitLabUser struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type GitLabMRAttributes struct {
+	ID           int    `json:"id"`
+	IID          int    `json:"iid"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	State        string `json:"state"`
+	Action       string `json:"action"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	URL          string `json:"url"`
+	OldRev       string `json:"oldrev"`
+	Draft        bool   `json:"draft"`
+	LastCommit   struct {
+		ID string `json:"id"`
+	} `json:"last_commit"`
+	Source struct {
+		Name string `json:"name"`
+	} `json:"source"`
+	Target struct {
+		Name string `json:"name"`
+	} `json:"target"`
+}
+
+type GitLabMREvent struct {
+	ObjectKind string     `json:"object_kind"`
+	EventType  string     `json:"event_type"`
+	User       GitLabUser `json:"user"`
+	Project    struct {
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+	ObjectAttributes GitLabMRAttributes `json:"object_attributes"`
+	Assignees        []GitLabUser       `json:"assignees"`
+	Reviewers        []GitLabUser       `json:"reviewers"`
+	Changes          struct {
+		Title *struct {
+			Previous string `json:"previous"`
+			Current  string `json:"current"`
+		} `json:"title"`
+		Description *struct {
+			Previous string `json:"previous"`
+			Current  string `json:"current"`
+		} `json:"description"`
+		TargetBranch *struct {
+			Previous string `json:"previous"`
+			Current  string `json:"current"`
+		} `json:"target_branch"`
+		Reviewers *struct {
+			Previous []GitLabUser `json:"previous"`
+			Current  []GitLabUser `json:"current"`
+		} `json:"reviewers"`
+	} `json:"changes"`
+}
+
+// Request came from GitLab if X-Gitlab-Event header is set
+func isGitLabEvent(gitlabEvent string) bool {
+	return gitlabEvent != ""
+}
+
+// Compare X-Gitlab-Token header value with configured token in constant time
+func verifyGitLabToken(token string) error {
+	if gitlabToken == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(gitlabToken)) != 1 {
+		rlog.Error("X-Gitlab-Token header is missing or doesn't match configured GITLAB_WEBHOOK_TOKEN")
+		return fmt.Errorf("%w: X-Gitlab-Token verification failed", ErrUnauthorized)
+	}
+	return nil
+}
+
+// GitLab hides emails in hooks by default ("[REDACTED]"), username is used as mention id then
+func (u GitLabUser) toBitBucketUser() BitBucketUser {
+	var user BitBucketUser
+	user.Name = u.Username
+	user.EmailAddress = u.Email
+	if !strings.Contains(user.EmailAddress, "@") {
+		user.EmailAddress = u.Username
+	}
+	user.DisplayName = u.Name
+	user.ID = u.ID
+	user.Slug = u.Username
+	return user
+}
+
+func gitLabRef(branch, commit, repository string) BitBucketRef {
+	var ref BitBucketRef
+	ref.ID = "refs/heads/" + branch
+	ref.DisplayID = branch
+	ref.LatestCommit = commit
+	ref.Type = "BRANCH"
+	ref.Repository.Slug = repository
+	ref.Repository.Name = repository
+	return ref
+}
+
+// Maps GitLab merge request action to BitBucket Server eventKey with the same meaning, empty if event should not be sent
+func gitLabEventKey(inventory GitLabMREvent) string {
+	switch inventory.ObjectAttributes.Action {
+	case "open", "reopen":
+		return "pr:opened"
+	case "update":
+		switch {
+		case inventory.ObjectAttributes.OldRev != "":
+			return "pr:from_ref_updated"
+		case inventory.Changes.Reviewers != nil:
+			return "pr:reviewer:updated"
+		case inventory.Changes.Title != nil, inventory.Changes.Description != nil, inventory.Changes.TargetBranch != nil:
+			return "pr:modified"
+		}
+	case "merge":
+		return "pr:merged"
+	case "close":
+		return "pr:declined"
+	case "approved", "approval":
+		return "pr:reviewer:approved"
+	case "unapproved", "unapproval":
+		return "pr:reviewer:unapproved"
+	}
+	return ""
+}
+
+// Returns users from list a which are not present in list b
+func gitLabUsersDiff(a, b []GitLabUser) []BitBucketUser {
+	var diff []BitBucketUser
+	for _, val := range a {
+		found := false
+		for _, other := range b {
+			if other.ID == val.ID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, val.toBitBucketUser())
+		}
+	}
+	return diff
+}
+
+// Parse GitLab Merge Request Hook json payload, maps data to build Teams notification webhook json
+func ParseGitLabMR(gitlabEvent string, eventJson []byte) ([]byte, error) {
+	if gitlabEvent != "Merge Request Hook" {
+		rlog.Debugf("GitLab event %s is not routed", gitlabEvent)
+		return []byte(""), ErrEventIgnored
+	}
+	var inventory GitLabMREvent
+	if err := json.Unmarshal(eventJson, &inventory); err != nil {
+		errMsg := fmt.Sprintf("Error Unmarshalling GitLab payload JSON : %s", err.Error())
+		rlog.Error(errMsg)
+		return []byte(""), errors.New(errMsg)
+	}
+	rlog.Tracef(0, "gitlab inventory : %+v\n", inventory)
+
+	var event BitBucketPREvent
+	event.EventKey = gitLabEventKey(inventory)
+	if event.EventKey == "" {
+		rlog.Debugf("GitLab merge request action '%s' is not routed", inventory.ObjectAttributes.Action)
+		return []byte(""), ErrEventIgnored
+	}
+	event.Actor = inventory.User.toBitBucketUser()
+
+	src := inventory.ObjectAttributes
+	pr := &event.PullRequest
+	pr.ID = src.IID
+	pr.Title = src.Title
+	pr.Description = src.Description
+	pr.State = strings.ToUpper(src.State)
+	pr.Open = src.State == "opened"
+	pr.Closed = !pr.Open
+	pr.FromRef = gitLabRef(src.SourceBranch, src.LastCommit.ID, src.Source.Name)
+	pr.ToRef = gitLabRef(src.TargetBranch, "", src.Target.Name)
+	pr.Links.Self = append(pr.Links.Self, struct {
+		Href string `json:"href"`
+	}{Href: src.URL})
+	// payload has no MR author object, actor is the author for open action only
+	if event.EventKey == "pr:opened" {
+		pr.Author.User = event.Actor
+	}
+	pr.Author.Role = "AUTHOR"
+	// both reviewers and assignees are mentioned
+	for _, val := range append(inventory.Reviewers, inventory.Assignees...) {
+		duplicate := false
+		for _, known := range pr.Reviewers {
+			if known.User.ID == val.ID {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			pr.Reviewers = append(pr.Reviewers, BitBucketParticipant{User: val.toBitBucketUser(), Role: "REVIEWER"})
+		}
+	}
+
+	event.PreviousFromHash = src.OldRev
+	if inventory.Changes.Title != nil {
+		event.PreviousTitle = inventory.Changes.Title.Previous
+	}
+	if inventory.Changes.Description != nil {
+		event.PreviousDescription = inventory.Changes.Description.Previous
+	}
+	if inventory.Changes.TargetBranch != nil {
+		previousTarget := gitLabRef(inventory.Changes.TargetBranch.Previous, "", src.Target.Name)
+		event.PreviousTarget = &previousTarget
+	}
+	if inventory.Changes.Reviewers != nil {
+		event.AddedReviewers = gitLabUsersDiff(inventory.Changes.Reviewers.Current, inventory.Changes.Reviewers.Previous)
+		event.RemovedReviewers = gitLabUsersDiff(inventory.Changes.Reviewers.Previous, inventory.Changes.Reviewers.Current)
+	}
+	if event.EventKey == "pr:reviewer:approved" || event.EventKey == "pr:reviewer:unapproved" {
+		event.Participant = &BitBucketParticipant{User: event.Actor, Role: "REVIEWER"}
+	}
+
+	return RenderPR(event)
+}
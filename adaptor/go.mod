@@ -3,6 +3,7 @@ module github.com/babinkos/webhook-bb-pr-teams-router-app-go/adaptor
 go 1.20
 
 require (
+	github.com/andybalholm/brotli v1.0.5
 	github.com/goccy/go-json v0.10.2
 	github.com/gofiber/fiber/v2 v2.46.0
 	github.com/romana/rlog v0.0.0-20220412051723-c08f605858a9
@@ -10,7 +11,6 @@ require (
 )
 
 require (
-	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/klauspost/compress v1.16.3 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Settings of the shared outbound Teams client
+type httpClientConfig struct {
+	dialTimeout         time.Duration
+	tlsHandshakeTimeout time.Duration
+	readTimeout         time.Duration
+	writeTimeout        time.Duration
+	maxConnsPerHost     int
+	maxIdleConnDuration time.Duration
+	proxy               proxyFunc // nil for direct connections
+}
+
+// TLS handshake over dialed connection with its own deadline, fasthttp would use write timeout for it
+func tlsHandshake(rawConn net.Conn, addr string, tlsConfig *tls.Config, timeout time.Duration) (net.Conn, error) {
+	config := tlsConfig.Clone()
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		config.ServerName = host
+	}
+	conn := tls.Client(rawConn, config)
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+	}
+	if err := conn.Handshake(); err != nil {
+		rawConn.Close()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, fasthttp.ErrTLSHandshakeTimeout
+		}
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Dial Teams host directly or through CONNECT tunnel of proxy chosen for it
+func (config httpClientConfig) dialRaw(addr string, isTLS bool, proxyTLSConfig *tls.Config) (net.Conn, error) {
+	if config.proxy == nil {
+		return fasthttp.DialTimeout(addr, config.dialTimeout)
+	}
+	proxyURL, err := config.proxy(isTLS, addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return fasthttp.DialTimeout(addr, config.dialTimeout)
+	}
+	return dialProxyTunnel(proxyURL, addr, config.dialTimeout, proxyTLSConfig)
+}
+
+// Long lived client shared by all deliveries, so connections and TLS sessions to Teams host are reused
+func newTeamsClient(config httpClientConfig, tlsConfig *tls.Config) *fasthttp.Client {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.ClientSessionCache == nil {
+		tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return &fasthttp.Client{
+		TLSConfig:           tlsConfig,
+		ReadTimeout:         config.readTimeout,
+		WriteTimeout:        config.writeTimeout,
+		MaxConnsPerHost:     config.maxConnsPerHost,
+		MaxIdleConnDuration: config.maxIdleConnDuration,
+		MaxConnWaitTimeout:  config.dialTimeout, // wait for free connection when all maxConnsPerHost are busy
+		ConfigureClient: func(hc *fasthttp.HostClient) error {
+			isTLS := hc.IsTLS
+			hc.Dial = func(addr string) (net.Conn, error) {
+				conn, err := config.dialRaw(addr, isTLS, tlsConfig)
+				if err != nil || !isTLS {
+					return conn, err
+				}
+				return tlsHandshake(conn, addr, tlsConfig, config.tlsHandshakeTimeout)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/romana/rlog"
+	"github.com/valyala/fasthttp"
+)
+
+// Teams stand-in accepting every card, client trusts its certificate
+func newTeamsStandIn(tb testing.TB) (*httptest.Server, *tls.Config) {
+	tb.Helper()
+	os.Setenv("RLOG_LOG_LEVEL", "NONE") // one log line per delivery would dominate timings
+	rlog.UpdateEnv()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("1"))
+	}))
+	tb.Cleanup(server.Close)
+	tlsConfig := &tls.Config{RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	return server, tlsConfig
+}
+
+func benchmarkDeliver(b *testing.B, client func() *fasthttp.Client, teamsURI string) {
+	payload := []byte(`{"type":"message","attachments":[]}`)
+	policy := retryPolicy{attempts: 1}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if result := deliverToTeams(client(), teamsURI, "bench", payload, policy); result.Failure("bench") != nil {
+			b.Fatal(result.Failure("bench"))
+		}
+	}
+}
+
+// Client created for every delivery, as before the shared client: new connection and full TLS handshake each time
+func BenchmarkDeliverPerRequestClient(b *testing.B) {
+	server, tlsConfig := newTeamsStandIn(b)
+	benchmarkDeliver(b, func() *fasthttp.Client {
+		return &fasthttp.Client{TLSConfig: tlsConfig}
+	}, server.URL+"/webhookb2/bench")
+}
+
+// Shared client of newTeamsClient, connection is kept alive between deliveries
+func BenchmarkDeliverSharedClient(b *testing.B) {
+	server, tlsConfig := newTeamsStandIn(b)
+	client := newTeamsClient(httpClientConfig{
+		dialTimeout:         5 * time.Second,
+		tlsHandshakeTimeout: 5 * time.Second,
+		readTimeout:         5 * time.Second,
+		writeTimeout:        5 * time.Second,
+		maxConnsPerHost:     4,
+		maxIdleConnDuration: time.Minute,
+	}, tlsConfig)
+	benchmarkDeliver(b, func() *fasthttp.Client {
+		return client
+	}, server.URL+"/webhookb2/bench")
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gofiber/fiber/v2"
+	"github.com/romana/rlog"
+)
+
+// Inbound payload limits of webhook routes: raw body size is limited by fiber BodyLimit,
+// decoded body size, JSON nesting depth, Content-Type and Content-Encoding are checked by Handler
+type payloadLimits struct {
+	maxBodySize  int
+	maxJSONDepth int
+}
+
+const supportedContentEncodings = "gzip, deflate, br, identity"
+
+var errBodyTooLarge = errors.New("request body is too large")
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// Decode body according to Content-Encoding, decoded body larger than maxSize is rejected,
+// so small compressed payload can't be inflated to huge one
+func decodeBody(encoding string, body []byte, maxSize int) ([]byte, error) {
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		if len(body) > maxSize {
+			return nil, errBodyTooLarge
+		}
+		return body, nil
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "deflate":
+		zlibReader, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zlibReader.Close()
+		reader = zlibReader
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > maxSize {
+		return nil, errBodyTooLarge
+	}
+	return decoded, nil
+}
+
+// Max nesting depth of JSON objects and arrays, brackets inside strings are skipped
+func jsonDepth(body []byte) int {
+	var depth, maxDepth int
+	var inString, escaped bool
+	for _, b := range body {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return maxDepth
+}
+
+// Middleware rejecting payloads: 415 for non JSON Content-Type or unsupported Content-Encoding,
+// 413 for decoded body above maxBodySize and 400 for corrupt encoding or JSON nested deeper than maxJSONDepth.
+// Encoded body is replaced by decoded one, so handler and signature check see plain JSON
+func (p *payloadLimits) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		mediaType, _, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+		if err != nil || mediaType != fiber.MIMEApplicationJSON {
+			errMsg := fmt.Sprintf("Unsupported Content-Type: %q, %s is required", c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON)
+			rlog.Error(errMsg)
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(415).SendString("Error: " + Redact(errMsg))
+		}
+		encoding := strings.ToLower(strings.TrimSpace(c.Get(fiber.HeaderContentEncoding)))
+		body, err := decodeBody(encoding, c.Request().Body(), p.maxBodySize) // c.Body() would inflate without limit
+		if errors.Is(err, errBodyTooLarge) {
+			errMsg := fmt.Sprintf("Request body is larger than %d bytes", p.maxBodySize)
+			rlog.Error(errMsg)
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(413).SendString("Error: " + Redact(errMsg))
+		} else if errors.Is(err, errUnsupportedEncoding) {
+			errMsg := err.Error()
+			rlog.Error(errMsg)
+			c.Set(fiber.HeaderAcceptEncoding, supportedContentEncodings)
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(415).SendString("Error: " + Redact(errMsg))
+		} else if err != nil {
+			errMsg := fmt.Sprintf("Request body can't be decoded: %s", err)
+			rlog.Error(errMsg)
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(400).SendString("Error: " + Redact(errMsg))
+		}
+		if encoding != "" && encoding != "identity" {
+			c.Request().SetBody(body)
+			c.Request().Header.Del(fiber.HeaderContentEncoding)
+		}
+		if depth := jsonDepth(body); depth > p.maxJSONDepth {
+			errMsg := fmt.Sprintf("JSON nesting depth %d exceeds limit of %d", depth, p.maxJSONDepth)
+			rlog.Error(errMsg)
+			c.Set("Content-Type", "text/plain; charset=utf-8")
+			return c.Status(400).SendString("Error: " + Redact(errMsg))
+		}
+		return c.Next()
+	}
+}
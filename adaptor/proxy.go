@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Outbound proxy of Teams client: every connection to Teams host is a CONNECT tunnel through proxy,
+// TLS to Teams is negotiated inside the tunnel. Proxy is chosen per destination by proxyFunc.
+type proxyFunc func(isTLS bool, addr string) (*url.URL, error)
+
+// Proxy from HTTP_PROXY/HTTPS_PROXY/NO_PROXY (lowercase variants too), nil proxy means direct connection
+func proxyFromEnvironment(isTLS bool, addr string) (*url.URL, error) {
+	scheme := "http"
+	if isTLS {
+		scheme = "https"
+	}
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+}
+
+// Explicit proxy for all destinations except hosts matching noProxy (comma separated hosts, domain suffixes or *)
+func explicitProxy(proxyURL *url.URL, noProxy string) proxyFunc {
+	var bypass []string
+	for _, item := range strings.Split(noProxy, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			bypass = append(bypass, item)
+		}
+	}
+	return func(isTLS bool, addr string) (*url.URL, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		host = strings.ToLower(host)
+		for _, item := range bypass {
+			if item == "*" || host == item || strings.HasSuffix(host, "."+strings.TrimPrefix(item, ".")) {
+				return nil, nil
+			}
+		}
+		return proxyURL, nil
+	}
+}
+
+// Parse proxy URL, scheme defaults to http, port to 80 (http) or 443 (https)
+func parseProxyURL(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	proxyURL, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+	if proxyURL.Hostname() == "" {
+		return nil, fmt.Errorf("proxy URL has no host")
+	}
+	return proxyURL, nil
+}
+
+// Open CONNECT tunnel to addr through proxy, with optional basic auth from proxy URL user info
+func dialProxyTunnel(proxyURL *url.URL, addr string, timeout time.Duration, proxyTLSConfig *tls.Config) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), map[string]string{"http": "80", "https": "443"}[proxyURL.Scheme])
+	}
+	conn, err := fasthttp.DialTimeout(proxyAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn, err = tlsHandshake(conn, proxyAddr, proxyTLSConfig, timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	connectResp, err := http.ReadResponse(reader, connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s CONNECT to %s failed: %w", proxyURL.Redacted(), addr, err)
+	}
+	connectResp.Body.Close()
+	if connectResp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s CONNECT to %s failed: %s", proxyURL.Redacted(), addr, connectResp.Status)
+	}
+	if reader.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s sent unexpected data after CONNECT response", proxyURL.Redacted())
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
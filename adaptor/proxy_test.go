@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// In-process CONNECT proxy requiring basic auth, counts tunnels it opened
+type connectProxy struct {
+	listener net.Listener
+	auth     string
+	tunnels  atomic.Int32
+}
+
+func newConnectProxy(t *testing.T, user, password string) *connectProxy {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &connectProxy{listener: listener, auth: "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.serve(conn)
+		}
+	}()
+	return proxy
+}
+
+func (p *connectProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+	if req.Header.Get("Proxy-Authorization") != p.auth {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"test\"\r\n\r\n")
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	p.tunnels.Add(1)
+	io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func (p *connectProxy) url(user, password string) *url.URL {
+	return &url.URL{Scheme: "http", User: url.UserPassword(user, password), Host: p.listener.Addr().String()}
+}
+
+func proxyClientConfig(proxy proxyFunc) httpClientConfig {
+	return httpClientConfig{
+		dialTimeout:         5 * time.Second,
+		tlsHandshakeTimeout: 5 * time.Second,
+		readTimeout:         5 * time.Second,
+		writeTimeout:        5 * time.Second,
+		maxConnsPerHost:     4,
+		maxIdleConnDuration: time.Minute,
+		proxy:               proxy,
+	}
+}
+
+func TestDeliverThroughProxyTunnel(t *testing.T) {
+	server, tlsConfig := newTeamsStandIn(t)
+	proxy := newConnectProxy(t, "user", "pa55word1")
+	client := newTeamsClient(proxyClientConfig(explicitProxy(proxy.url("user", "pa55word1"), "")), tlsConfig)
+
+	result := deliverToTeams(client, server.URL+"/webhookb2/proxy", "proxy-ok", []byte(`{}`), retryPolicy{attempts: 1})
+	if err := result.Failure("proxy-ok"); err != nil {
+		t.Fatalf("delivery through proxy failed: %v", err)
+	}
+	if tunnels := proxy.tunnels.Load(); tunnels != 1 {
+		t.Fatalf("expected 1 CONNECT tunnel, got %d", tunnels)
+	}
+}
+
+func TestDeliverThroughProxyWrongPassword(t *testing.T) {
+	server, tlsConfig := newTeamsStandIn(t)
+	proxy := newConnectProxy(t, "user", "pa55word1")
+	client := newTeamsClient(proxyClientConfig(explicitProxy(proxy.url("user", "wrong"), "")), tlsConfig)
+
+	result := deliverToTeams(client, server.URL+"/webhookb2/proxy", "proxy-407", []byte(`{}`), retryPolicy{attempts: 1})
+	if result.Err == nil {
+		t.Fatalf("expected error, got HTTP code %d", result.Code)
+	}
+	if !strings.Contains(result.Err.Error(), "407") {
+		t.Fatalf("expected 407 from proxy, got: %v", result.Err)
+	}
+	if strings.Contains(result.Err.Error(), "wrong") {
+		t.Fatalf("proxy password leaked to error: %v", result.Err)
+	}
+	if tunnels := proxy.tunnels.Load(); tunnels != 0 {
+		t.Fatalf("expected no CONNECT tunnel, got %d", tunnels)
+	}
+}
+
+func TestDeliverNoProxyBypass(t *testing.T) {
+	server, tlsConfig := newTeamsStandIn(t)
+	proxy := newConnectProxy(t, "user", "pa55word1")
+	client := newTeamsClient(proxyClientConfig(explicitProxy(proxy.url("user", "pa55word1"), "example.org, 127.0.0.1")), tlsConfig)
+
+	result := deliverToTeams(client, server.URL+"/webhookb2/proxy", "no-proxy", []byte(`{}`), retryPolicy{attempts: 1})
+	if err := result.Failure("no-proxy"); err != nil {
+		t.Fatalf("direct delivery failed: %v", err)
+	}
+	if tunnels := proxy.tunnels.Load(); tunnels != 0 {
+		t.Fatalf("expected TEAMS_NO_PROXY host to bypass proxy, got %d CONNECT tunnels", tunnels)
+	}
+}
+
+func TestExplicitProxyNoProxyMatching(t *testing.T) {
+	proxyURL := &url.URL{Scheme: "http", Host: "proxy.corp:3128"}
+	proxy := explicitProxy(proxyURL, "internal.corp, .office.com")
+	for addr, proxied := range map[string]bool{
+		"internal.corp:443":                    false,
+		"api.internal.corp:443":                false,
+		"somecorp.webhook.office.com:443":      false,
+		"office.com:443":                       true, // leading dot matches subdomains only
+		"notinternal.corp:443":                 true,
+		"somecorp.webhook.office.com.evil:443": true,
+	} {
+		got, err := proxy(true, addr)
+		if err != nil {
+			t.Fatalf("%s: %v", addr, err)
+		}
+		if (got != nil) != proxied {
+			t.Errorf("%s: proxied = %t, want %t", addr, got != nil, proxied)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// Durable delivery queue for async mode: every job is a JSON file in queue directory, written with fsync
+// before request is acknowledged with 202 and removed after delivery. Jobs left by crash or restart
+// are delivered again on startup, so delivery is at least once.
+// Job files contain Teams webhook URL, so directory and files are accessible to the owner only.
+type deliveryJob struct {
+	ID         string    `json:"id"`
+	RequestID  string    `json:"requestId"`
+	Route      string    `json:"route"`
+	TeamsURI   string    `json:"teamsUri"`
+	Payload    []byte    `json:"payload"`
+	Keys       []string  `json:"keys"`
+	EnqueuedAt time.Time `json:"enqueuedAt"`
+}
+
+type deliveryQueue struct {
+	dir      string
+	maxJobs  int
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  []string // job IDs in delivery order
+	active   int
+	delayed  int // jobs waiting for delivery retry, their files are kept
+	reserved int // slots of jobs being written, counted against maxJobs
+}
+
+var ErrQueueFull = errors.New("delivery queue is full")
+
+// Open queue directory, jobs found there are queued for delivery in enqueue order
+func newDeliveryQueue(dir string, maxJobs int) (*deliveryQueue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	q := &deliveryQueue{dir: dir, maxJobs: maxJobs}
+	q.cond = sync.NewCond(&q.mu)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(dir, name)) // job not acknowledged before crash
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		q.pending = append(q.pending, strings.TrimSuffix(name, ".json"))
+	}
+	sort.Strings(q.pending) // job ID starts with enqueue time
+	if len(q.pending) > 0 {
+		rlog.Infof("Recovered %d undelivered jobs from QUEUE_DIR", len(q.pending))
+	}
+	return q, nil
+}
+
+func (q *deliveryQueue) jobFile(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// Persist job and queue it for delivery, returns after job file is synced to disk
+func (q *deliveryQueue) Enqueue(job *deliveryJob) error {
+	q.mu.Lock()
+	if q.maxJobs > 0 && len(q.pending)+q.active+q.delayed+q.reserved >= q.maxJobs {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.reserved++
+	q.mu.Unlock()
+	if err := q.write(job); err != nil {
+		q.mu.Lock()
+		q.reserved--
+		q.mu.Unlock()
+		return err
+	}
+	q.mu.Lock()
+	q.reserved--
+	q.pending = append(q.pending, job.ID)
+	q.mu.Unlock()
+	q.cond.Signal()
+	return nil
+}
+
+func (q *deliveryQueue) write(job *deliveryJob) error {
+	job.EnqueuedAt = time.Now().UTC()
+	id, err := newRecordID(job.EnqueuedAt)
+	if err != nil {
+		return err
+	}
+	job.ID = id
+	content, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(q.dir, job.ID+".json", content)
+}
+
+// Number of jobs waiting and being delivered
+func (q *deliveryQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) + q.active + q.delayed
+}
+
+func (q *deliveryQueue) next() string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.pending) == 0 {
+		q.cond.Wait()
+	}
+	id := q.pending[0]
+	q.pending = q.pending[1:]
+	q.active++
+	return id
+}
+
+func (q *deliveryQueue) done(id string) {
+	if err := os.Remove(q.jobFile(id)); err != nil && !os.IsNotExist(err) {
+		rlog.Errorf("Can't remove delivered job %s from QUEUE_DIR: %s", id, err)
+	}
+	q.mu.Lock()
+	q.active--
+	q.mu.Unlock()
+}
+
+// Keep job file and queue job again after delay
+func (q *deliveryQueue) retryLater(id string, delay time.Duration) {
+	q.mu.Lock()
+	q.active--
+	q.delayed++
+	q.mu.Unlock()
+	time.AfterFunc(delay, func() {
+		q.mu.Lock()
+		q.delayed--
+		q.pending = append(q.pending, id)
+		q.mu.Unlock()
+		q.cond.Signal()
+	})
+}
+
+// Start workers delivering queued jobs, deliver returns delay of next delivery attempt of job
+// or 0 when job is done and its file is removed
+func (q *deliveryQueue) Start(workers int, deliver func(job *deliveryJob) time.Duration) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				id := q.next()
+				content, err := os.ReadFile(q.jobFile(id))
+				if err != nil {
+					rlog.Errorf("Can't read queued job %s: %s", id, err)
+					q.done(id)
+					continue
+				}
+				var job deliveryJob
+				if err := json.Unmarshal(content, &job); err != nil {
+					rlog.Errorf("Malformed queued job %s is dropped: %s", id, err)
+					q.done(id)
+					continue
+				}
+				if delay := deliver(&job); delay > 0 {
+					q.retryLater(id, delay)
+					continue
+				}
+				q.done(id)
+			}
+		}()
+	}
+}
+
+// Record ID is creation time with random suffix, so sorted IDs are in creation order
+func newRecordID(now time.Time) (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%019d-%x", now.UnixNano(), random), nil
+}
+
+// Write file via temp file, fsync and rename, so partially written file is never read back after crash
+func writeFileSynced(dir string, name string, content []byte) error {
+	tmp, err := os.CreateTemp(dir, "job-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
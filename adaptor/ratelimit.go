@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/romana/rlog"
+)
+
+// Token bucket per key: bucket holds up to burst tokens, refilled with rate tokens per second,
+// every request takes one token and is rejected when bucket is empty
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	hideKeys  bool // keys are webhook routes with Teams credentials, state shows their hashes only
+	buckets   map[string]*tokenBucket
+	rejected  uint64
+	lastPurge time.Time
+}
+
+// Limiter with rate <= 0 allows everything
+func newRateLimiter(rate float64, burst int, hideKeys bool) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), hideKeys: hideKeys, buckets: make(map[string]*tokenBucket)}
+}
+
+// Take token for key, returns false and time until next token when bucket is empty
+func (l *rateLimiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastPurge) > time.Minute {
+		// bucket refilled to full is the same as missing one
+		for bucketKey, bucket := range l.buckets {
+			if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, bucketKey)
+			}
+		}
+		l.lastPurge = now
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.last).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		l.rejected++
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+type rateLimiterBucketState struct {
+	Key    string  `json:"key"`
+	Tokens float64 `json:"tokens"`
+}
+
+type rateLimiterState struct {
+	Rate     float64                  `json:"rate"`
+	Burst    float64                  `json:"burst"`
+	Rejected uint64                   `json:"rejected"`
+	Buckets  []rateLimiterBucketState `json:"buckets"`
+}
+
+// Snapshot of limiter with tokens refilled up to now, buckets with least tokens first
+func (l *rateLimiter) State(now time.Time) rateLimiterState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := rateLimiterState{Rate: l.rate, Burst: l.burst, Rejected: l.rejected, Buckets: []rateLimiterBucketState{}}
+	for key, bucket := range l.buckets {
+		tokens := math.Min(bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate, l.burst)
+		if l.hideKeys {
+			key = routeHash(key)
+		}
+		state.Buckets = append(state.Buckets, rateLimiterBucketState{Key: key, Tokens: math.Floor(tokens*100) / 100})
+	}
+	sort.Slice(state.Buckets, func(i, j int) bool {
+		return state.Buckets[i].Tokens < state.Buckets[j].Tokens
+	})
+	return state
+}
+
+// Reject request with 429 and Retry-After (whole seconds, rounded up) if key has no tokens left
+func (l *rateLimiter) Reject(c *fiber.Ctx, key string, what string) (bool, error) {
+	allowed, wait := l.Allow(key, time.Now())
+	if allowed {
+		return false, nil
+	}
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if l.hideKeys {
+		key = routeHash(key)
+	}
+	errMsg := fmt.Sprintf("Rate limit per %s exceeded for %s, retry after %d s", what, key, retryAfter)
+	rlog.Error(errMsg)
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprint(retryAfter))
+	c.Set("Content-Type", "text/plain; charset=utf-8")
+	return true, c.Status(429).SendString("Error: " + Redact(errMsg))
+}
+
+// Middleware limiting requests per key returned by keyFunc
+func (l *rateLimiter) Handler(keyFunc func(c *fiber.Ctx) string, what string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if rejected, err := l.Reject(c, keyFunc(c), what); rejected {
+			return err
+		}
+		return c.Next()
+	}
+}
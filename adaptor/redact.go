@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/romana/rlog"
+)
+
+// Every log line (rlog and access log) and error message returned to client goes through Redact,
+// so Teams webhook credentials, emails and configured secrets are never printed, regardless of log level
+
+var webhookPathRegexp = regexp.MustCompile(`/webhookb2/([^/\s"'?]+)/IncomingWebhook/([^/\s"'?]+)/([^/\s"'?\\]+)`)
+var hashedPathPartRegexp = regexp.MustCompile(`^[0-9a-f]{7}$`)
+var emailRegexp = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+\.)+[A-Za-z]{2,}`)
+
+// Secrets shorter than this are not redacted, as masking of short common substrings would garble logs
+const minRedactedSecretLength = 6
+
+type secretRegistry struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+var redactedSecrets = &secretRegistry{}
+
+// Register configured secret value to be masked in logs
+func RegisterSecret(secret string) {
+	if len(secret) < minRedactedSecretLength {
+		return
+	}
+	redactedSecrets.mu.Lock()
+	defer redactedSecrets.mu.Unlock()
+	for _, known := range redactedSecrets.secrets {
+		if known == secret {
+			return
+		}
+	}
+	redactedSecrets.secrets = append(redactedSecrets.secrets, secret)
+	// longest first, so secret containing other secret is masked entirely
+	sort.Slice(redactedSecrets.secrets, func(i, j int) bool {
+		return len(redactedSecrets.secrets[i]) > len(redactedSecrets.secrets[j])
+	})
+}
+
+// Mask configured secrets, webhook path tokens (replaced by short sha256 like in access log) and emails (local part)
+func Redact(s string) string {
+	redactedSecrets.mu.RLock()
+	for _, secret := range redactedSecrets.secrets {
+		s = strings.ReplaceAll(s, secret, "[REDACTED]")
+	}
+	redactedSecrets.mu.RUnlock()
+	s = webhookPathRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		parts := webhookPathRegexp.FindStringSubmatch(match)
+		if hashedPathPartRegexp.MatchString(parts[1]) && hashedPathPartRegexp.MatchString(parts[2]) && hashedPathPartRegexp.MatchString(parts[3]) {
+			return match // already redacted, e.g. message with redacted destination is logged
+		}
+		return hashedWebhookPath(parts[1], parts[2], parts[3])
+	})
+	s = emailRegexp.ReplaceAllStringFunc(s, func(match string) string {
+		_, domain, _ := strings.Cut(match, "@")
+		return "***@" + domain
+	})
+	return s
+}
+
+// Writer which redacts every write before passing it to underlying writer, loggers issue one write per line
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Route rlog output through redaction, returned writer is used for access log too.
+// rlog re-reads its settings every RLOG_CONF_CHECK_INTERVAL and recreates its writers then, which would drop
+// redaction, so the re-check is disabled. RLOG_LOG_FILE is written by adaptor itself, redacted like stream output.
+func setupLogging(stream io.Writer) (io.Writer, error) {
+	logFile := os.Getenv("RLOG_LOG_FILE")
+	os.Unsetenv("RLOG_LOG_FILE")
+	os.Setenv("RLOG_LOG_STREAM", "stdout")
+	os.Setenv("RLOG_CONF_CHECK_INTERVAL", "0")
+	rlog.UpdateEnv()
+	out := redactingWriter{out: stream}
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			rlog.SetOutput(out)
+			return out, err
+		}
+		out = redactingWriter{out: io.MultiWriter(stream, file)}
+	}
+	rlog.SetOutput(out) // every log line is redacted regardless of log level
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// Buffer shared by rlog and access log writers
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRedact(t *testing.T) {
+	RegisterSecret("route-secret-value")
+	for input, want := range map[string]string{
+		"secret route-secret-value in log": "secret [REDACTED] in log",
+		"author jdoe@example.org":          "author ***@example.org",
+		"hook path: /webhookb2/aaaaaaaa-1111@bbbb/IncomingWebhook/0123456789abcdef/cccc-dddd": "hook path: " + hashedWebhookPath("aaaaaaaa-1111@bbbb", "0123456789abcdef", "cccc-dddd"),
+		"short abc is kept": "short abc is kept",
+	} {
+		if got := Redact(input); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", input, got, want)
+		}
+	}
+	once := Redact("/webhookb2/aaaaaaaa/IncomingWebhook/bbbbbbbb/cccccccc")
+	if twice := Redact(once); twice != once {
+		t.Errorf("redacted path was hashed again: %q -> %q", once, twice)
+	}
+}
+
+func TestLogRedactionSurvivesConfigRecheck(t *testing.T) {
+	t.Setenv("RLOG_LOG_LEVEL", "INFO")
+	t.Setenv("RLOG_CONF_CHECK_INTERVAL", "1") // would re-init rlog writers after a second
+	logFile := filepath.Join(t.TempDir(), "adaptor.log")
+	t.Setenv("RLOG_LOG_FILE", logFile)
+	t.Cleanup(func() {
+		os.Unsetenv("RLOG_CONF_CHECK_INTERVAL")
+		rlog.UpdateEnv()
+	})
+	RegisterSecret("logged-secret-value")
+	stream := &lockedBuffer{}
+	if _, err := setupLogging(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	rlog.Infof("first: logged-secret-value")
+	time.Sleep(1100 * time.Millisecond)
+	rlog.Infof("second: logged-secret-value")
+
+	fileContent, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, output := range map[string]string{"stream": stream.String(), "RLOG_LOG_FILE": string(fileContent)} {
+		if strings.Contains(output, "logged-secret-value") {
+			t.Errorf("secret leaked to %s: %s", name, output)
+		}
+		if strings.Count(output, "[REDACTED]") != 2 {
+			t.Errorf("expected both lines redacted in %s, got: %s", name, output)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// Opaque aliases for Teams webhook URLs, so Teams credentials are kept server side and source webhook
+// is configured with /hooks/alias URL only. File is JSON object alias -> full Teams webhook URL:
+// {"team-a": "https://somecorp.webhook.office.com/webhookb2/uid1@uid2/IncomingWebhook/uid3/uid4"}
+type teamsRouteTable struct {
+	mu     sync.RWMutex
+	routes map[string]string
+}
+
+var teamsRoutes = &teamsRouteTable{routes: make(map[string]string)}
+
+// Load aliases from file, current table is kept if file is malformed
+func (t *teamsRouteTable) Load(fileName string) error {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	routes := make(map[string]string)
+	if err := json.Unmarshal(content, &routes); err != nil {
+		return err
+	}
+	for alias, target := range routes {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			// URL itself is not printed as it contains Teams credentials
+			return fmt.Errorf("alias %s has malformed Teams webhook URL", alias)
+		}
+	}
+	for _, target := range routes {
+		RegisterSecret(target)
+	}
+	t.mu.Lock()
+	t.routes = routes
+	t.mu.Unlock()
+	rlog.Infof("Loaded %d hook aliases", len(routes))
+	return nil
+}
+
+func (t *teamsRouteTable) Lookup(alias string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	target, ok := t.routes[alias]
+	return target, ok
+}
+
+// Reload aliases on SIGHUP, so rotated Teams connector URL is applied without restart
+func (t *teamsRouteTable) ReloadOnSignal(fileName string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		if err := t.Load(fileName); err != nil {
+			rlog.Errorf("Can't reload TEAMS_ROUTES_FILE: %s ; Error: %s", fileName, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/romana/rlog"
+)
+
+// BitBucket Server/DC and Cloud sign payload with webhook secret: X-Hub-Signature: sha256=<hex HMAC-SHA256 of body>
+// https://confluence.atlassian.com/bitbucketserver/manage-webhooks-938025878.html
+
+// Secret used for routes which have no own secret, signature check is disabled when both are empty
+var bitbucketSecret string
+
+// Per route secrets, route is "id1/id2/id3" part of webhook path
+var bitbucketRouteSecrets map[string]string
+
+// Load JSON object with route to secret mapping from file
+func loadRouteSecrets(fileName string) (map[string]string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]string)
+	if err := json.Unmarshal(content, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// Secret configured for route, route secret has priority over global one
+func routeSecret(route string) string {
+	if secret, ok := bitbucketRouteSecrets[route]; ok {
+		return secret
+	}
+	return bitbucketSecret
+}
+
+// Short sha256 of route to identify it in logs without leaking webhook credentials
+func routeHash(route string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(route)))[0:7]
+}
+
+// Only BitBucket and GitHub sign payload this way, GitLab token and Gitea signature are verified by VerifyEventSource
+// and Azure DevOps service hooks can't sign payload at all
+func usesHubSignature(header func(key string, defaultValue ...string) string, body []byte) bool {
+	if isGiteaEvent(header("X-Gitea-Event", header("X-Forgejo-Event"))) || isGitLabEvent(header("X-Gitlab-Event")) {
+		return false
+	}
+	if isGitHubEvent(header("X-GitHub-Event")) {
+		return true
+	}
+	var probe struct {
+		EventType   string `json:"eventType"`
+		PublisherID string `json:"publisherId"`
+	}
+	return json.Unmarshal(body, &probe) != nil || !isAzureEvent(probe.PublisherID, probe.EventType)
+}
+
+// Compare HMAC-SHA256 of body with X-Hub-Signature-256 header value in constant time (GitHub),
+// X-Hub-Signature is used only if it carries sha256 signature (BitBucket), GitHub puts sha1 one there.
+// signed is true when payload signature was verified
+func VerifyHubSignature(route string, header func(key string, defaultValue ...string) string, body []byte) (signed bool, err error) {
+	secret := routeSecret(route)
+	if secret == "" || !usesHubSignature(header, body) {
+		return false, nil
+	}
+	signature := header("X-Hub-Signature-256")
+	if legacy := header("X-Hub-Signature"); signature == "" && strings.HasPrefix(legacy, "sha256=") {
+		signature = legacy
+	}
+	if signature == "" {
+		rlog.Errorf("X-Hub-Signature(-256) header with sha256 signature is missing, route %s requires signed payload", routeHash(route))
+		return false, fmt.Errorf("%w: X-Hub-Signature(-256) header with sha256=<hex> signature is missing", ErrUnauthorized)
+	}
+	algorithm, digest, found := strings.Cut(signature, "=")
+	if !found || algorithm != "sha256" {
+		rlog.Errorf("X-Hub-Signature header has unsupported format, route %s", routeHash(route))
+		return false, fmt.Errorf("%w: X-Hub-Signature must be sha256=<hex>", ErrUnauthorized)
+	}
+	actual, err := hex.DecodeString(digest)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if err != nil || !hmac.Equal(actual, mac.Sum(nil)) {
+		rlog.Errorf("X-Hub-Signature doesn't match payload, route %s", routeHash(route))
+		return false, fmt.Errorf("%w: X-Hub-Signature verification failed", ErrUnauthorized)
+	}
+	rlog.Debugf("X-Hub-Signature verified, route %s", routeHash(route))
+	return true, nil
+}
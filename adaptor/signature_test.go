@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func hubSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Header getter with fiber Ctx.Get semantics
+func testHeaders(headers map[string]string) func(key string, defaultValue ...string) string {
+	return func(key string, defaultValue ...string) string {
+		if value, ok := headers[key]; ok {
+			return value
+		}
+		if len(defaultValue) > 0 {
+			return defaultValue[0]
+		}
+		return ""
+	}
+}
+
+func TestVerifyHubSignature(t *testing.T) {
+	bitbucketSecret = "global-secret"
+	bitbucketRouteSecrets = map[string]string{"a/b/c": "route-secret"}
+	t.Cleanup(func() {
+		bitbucketSecret = ""
+		bitbucketRouteSecrets = nil
+	})
+	body := []byte(`{"eventKey":"pr:opened"}`)
+	azureBody := []byte(`{"publisherId":"tfs","eventType":"git.pullrequest.created"}`)
+	for name, tc := range map[string]struct {
+		route   string
+		headers map[string]string
+		body    []byte
+		signed  bool
+		fails   bool
+	}{
+		"bitbucket sha256":             {route: "x/y/z", headers: map[string]string{"X-Hub-Signature": hubSignature("global-secret", body)}, body: body, signed: true},
+		"route secret":                 {route: "a/b/c", headers: map[string]string{"X-Hub-Signature": hubSignature("route-secret", body)}, body: body, signed: true},
+		"global secret on route":       {route: "a/b/c", headers: map[string]string{"X-Hub-Signature": hubSignature("global-secret", body)}, body: body, fails: true},
+		"unsigned":                     {route: "x/y/z", body: body, fails: true},
+		"github sha256 header":         {route: "x/y/z", headers: map[string]string{"X-GitHub-Event": "pull_request", "X-Hub-Signature": "sha1=0000", "X-Hub-Signature-256": hubSignature("global-secret", body)}, body: body, signed: true},
+		"github sha1 only":             {route: "x/y/z", headers: map[string]string{"X-GitHub-Event": "pull_request", "X-Hub-Signature": "sha1=0000"}, body: body, fails: true},
+		"tampered body":                {route: "x/y/z", headers: map[string]string{"X-Hub-Signature": hubSignature("global-secret", body)}, body: []byte(`{"eventKey":"pr:merged"}`), fails: true},
+		"gitlab verified by own token": {route: "x/y/z", headers: map[string]string{"X-Gitlab-Event": "Merge Request Hook"}, body: body},
+		"gitea verified by own secret": {route: "x/y/z", headers: map[string]string{"X-Gitea-Event": "pull_request"}, body: body},
+		"azure can't sign":             {route: "x/y/z", body: azureBody},
+	} {
+		signed, err := VerifyHubSignature(tc.route, testHeaders(tc.headers), tc.body)
+		if tc.fails {
+			if !errors.Is(err, ErrUnauthorized) {
+				t.Errorf("%s: expected ErrUnauthorized, got %v", name, err)
+			}
+			continue
+		}
+		if err != nil || signed != tc.signed {
+			t.Errorf("%s: got signed %t, error %v, want signed %t", name, signed, err, tc.signed)
+		}
+	}
+}
+
+func TestVerifyEventSource(t *testing.T) {
+	giteaSecret, gitlabToken = "gitea-secret", "gitlab-token"
+	t.Cleanup(func() {
+		giteaSecret, gitlabToken = "", ""
+	})
+	body := []byte(`{"action":"opened"}`)
+	giteaSignature := hubSignature("gitea-secret", body)[len("sha256="):]
+	for name, tc := range map[string]struct {
+		headers map[string]string
+		fails   bool
+	}{
+		"gitea signed":         {headers: map[string]string{"X-Gitea-Event": "pull_request", "X-Gitea-Signature": giteaSignature}},
+		"forgejo signed":       {headers: map[string]string{"X-Forgejo-Event": "pull_request", "X-Forgejo-Signature": giteaSignature}},
+		"gitea unsigned ping":  {headers: map[string]string{"X-Gitea-Event": "ping", "X-GitHub-Event": "ping"}, fails: true},
+		"gitlab token":         {headers: map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": "gitlab-token"}},
+		"gitlab wrong token":   {headers: map[string]string{"X-Gitlab-Event": "Merge Request Hook", "X-Gitlab-Token": "other"}, fails: true},
+		"other source skipped": {headers: map[string]string{"X-GitHub-Event": "pull_request"}},
+	} {
+		err := VerifyEventSource(testHeaders(tc.headers), body)
+		if tc.fails != errors.Is(err, ErrUnauthorized) || (!tc.fails && err != nil) {
+			t.Errorf("%s: got error %v, want failure %t", name, err, tc.fails)
+		}
+	}
+}
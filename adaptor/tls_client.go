@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/romana/rlog"
+)
+
+// Add PEM certificates from file or from every file in directory to pool, returns number of files loaded
+func appendCAs(pool *x509.CertPool, caPath string) (int, error) {
+	info, err := os.Stat(caPath)
+	if err != nil {
+		return 0, err
+	}
+	var files []string
+	if info.IsDir() {
+		entries, err := os.ReadDir(caPath)
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(caPath, entry.Name()))
+			}
+		}
+	} else {
+		files = append(files, caPath)
+	}
+	var loaded int
+	for _, fileName := range files {
+		caPEM, err := os.ReadFile(fileName)
+		if err != nil {
+			return loaded, err
+		}
+		if pool.AppendCertsFromPEM(caPEM) {
+			loaded++
+		} else if !info.IsDir() {
+			return loaded, fmt.Errorf("no PEM certificates found in %s", fileName)
+		} else {
+			rlog.Debugf("No PEM certificates found in %s, skipped", fileName)
+		}
+	}
+	return loaded, nil
+}
+
+// Build TLS config of outbound Teams client: system roots extended with CAs from caPath (file or directory),
+// client certificate is presented if certFile and keyFile are set
+func newClientTLSConfig(caPath, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		Certificates:       []tls.Certificate{},
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caPath != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			rlog.Warnf("System CA pool is not available, only TLS_CA_FILE is trusted: %s", err)
+			pool = x509.NewCertPool()
+		}
+		loaded, err := appendCAs(pool, caPath)
+		if err != nil {
+			return nil, err
+		}
+		if loaded == 0 {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caPath)
+		}
+		tlsConfig.RootCAs = pool
+		rlog.Infof("Loaded CA certificates from %d file(s) of %s", loaded, caPath)
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("both client certificate and key files must be set")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	return tlsConfig, nil
+}
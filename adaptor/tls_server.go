@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// Serving certificate which is reloaded when cert or key file changes (cert-manager rotation)
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Latest modification time of cert and key files, symlinks (k8s secret volumes) are followed
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, fileName := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(fileName)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	if leaf, parseErr := x509.ParseCertificate(cert.Certificate[0]); parseErr == nil {
+		rlog.Infof("TLS certificate loaded: subject: %s ; not after: %s", leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Check files every interval and reload certificate if they changed, old certificate is kept on error
+func (r *certReloader) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		modTime, err := r.filesModTime()
+		if err != nil {
+			rlog.Errorf("TLS certificate files check error: %s", err)
+			continue
+		}
+		r.mu.RLock()
+		changed := !modTime.Equal(r.modTime)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := r.load(); err != nil {
+			rlog.Errorf("TLS certificate reload error, previous certificate is used: %s", err)
+		}
+	}
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Build TLS config of inbound listener, client certificates signed by CA from clientCAFile are required if it's set
+func newServerTLSConfig(reloader *certReloader, clientCAFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if clientCAFile != "" {
+		caPEM, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
@@ -6,4 +6,6 @@ openssl req -new -key ca-privatekey.pem -sha256 -out csr.pem
 openssl x509 -req -days 3000 -in csr.pem -sha256 -signkey ca-privatekey.pem -out ca-public.crt
 
 openssl req -newkey rsa:4096 -sha256 -nodes -subj "/CN=localhost" -keyout privkey_user.pem -out "csr_user.pem"
-openssl x509 -req -in csr_user.pem -days 3000 -CA ca-public.crt -CAkey ca-privatekey.pem -CAcreateserial -out public_user.crt
+# SAN is required by Go TLS clients, CN alone is not verified
+printf "subjectAltName=DNS:localhost,IP:127.0.0.1,IP:%s\n" "$(hostname -I | cut -d' ' -f1)" > san_user.ext
+openssl x509 -req -in csr_user.pem -days 3000 -CA ca-public.crt -CAkey ca-privatekey.pem -CAcreateserial -extfile san_user.ext -out public_user.crt